@@ -0,0 +1,401 @@
+// テキストで書いたバイトコードを compiler.Bytecode に組み立てる
+//
+// 構文解析やコンパイラを通さずにVMの境界条件を試すためのもの
+// disasm.Disassemble の出力や code.Instructions.String() の出力をそのまま読める
+//
+//	.const                  定数表 1行1定数
+//	   0: INTEGER 5
+//	   1: STRING "hello"
+//	   2: FLOAT 1.5
+//	   3: fn add            関数 命令列は .fn 3 に書く
+//	.main                   メインの命令列 (ディレクティブより前の命令もここに入る)
+//	0000 OpConstant 0       先頭の数字は命令位置 読み飛ばす
+//	     OpJumpNotTruthy L0 ジャンプ先はラベルで書ける
+//	L0:
+//	     .byte 255          生のバイト
+//	.fn 3 locals=2 params=2 定数3の関数の命令列
+//
+// disasm.Disassemble の見出しも同じ意味で読める
+//
+//	== constants ==                                 .const
+//	== main ==                                      .main
+//	== fn add (constant 3, locals 2, params 2) ==   .fn 3 locals=2 params=2
+//
+// ; から行末まではコメント
+package asm
+
+import (
+	"fmt"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/object"
+	"strconv"
+	"strings"
+)
+
+// 組み立てに失敗した行と理由
+type Error struct {
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// 命令名から命令を引く表 code.Lookup で全ての命令を調べて作る
+var opcodes = map[string]code.Opcode{}
+
+func init() {
+	for i := 0; i < 256; i++ {
+		if def, err := code.Lookup(byte(i)); err == nil {
+			opcodes[def.Name] = code.Opcode(i)
+		}
+	}
+}
+
+// 命令列1本分 (メインか関数1つ)
+type section struct {
+	lines  []sourceLine
+	labels map[string]int
+	// 次の命令が置かれる位置
+	size int
+}
+
+type sourceLine struct {
+	number int
+	fields []string
+}
+
+type assembler struct {
+	main      *section
+	current   *section
+	functions map[int]*section
+	constants []object.Object
+	// 定数を書いた行 エラー表示用
+	constLines []int
+	// 今 .const を読んでいるか
+	inConst bool
+}
+
+func Assemble(src string) (*compiler.Bytecode, error) {
+	a := &assembler{
+		functions: map[int]*section{},
+		constants: []object.Object{},
+	}
+	a.main = newSection()
+	a.current = a.main
+
+	for i, line := range strings.Split(src, "\n") {
+		if err := a.readLine(i+1, line); err != nil {
+			return nil, err
+		}
+	}
+
+	for index, c := range a.constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		s, ok := a.functions[index]
+		if !ok {
+			return nil, &Error{Line: a.constLines[index], Message: fmt.Sprintf("constant %d has no .fn section", index)}
+		}
+		ins, err := s.assemble()
+		if err != nil {
+			return nil, err
+		}
+		fn.Instructions = ins
+	}
+
+	ins, err := a.main.assemble()
+	if err != nil {
+		return nil, err
+	}
+
+	return &compiler.Bytecode{Instructions: ins, Constants: a.constants}, nil
+}
+
+func newSection() *section {
+	return &section{labels: map[string]int{}}
+}
+
+func (a *assembler) readLine(number int, line string) error {
+	line = strings.TrimSpace(stripComment(line))
+	if line == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(line, "==") {
+		return a.readHeader(number, line)
+	}
+
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ".const":
+		a.inConst = true
+		return nil
+	case ".main":
+		a.inConst = false
+		a.current = a.main
+		return nil
+	case ".fn":
+		a.inConst = false
+		return a.startFunction(number, fields[1:])
+	}
+
+	if a.inConst {
+		return a.readConstant(number, line)
+	}
+
+	return a.current.add(number, fields)
+}
+
+// == 見出し ==
+func (a *assembler) readHeader(number int, line string) error {
+	title := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "=="), "=="))
+
+	switch title {
+	case "constants":
+		return a.readLine(number, ".const")
+	case "main":
+		return a.readLine(number, ".main")
+	}
+
+	// 関数名は読み飛ばし、カッコの中の数字だけを使う
+	var index, locals, params int
+	open := strings.LastIndex(title, "(")
+	if open < 0 {
+		return &Error{Line: number, Message: fmt.Sprintf("unknown header %q", line)}
+	}
+	if _, err := fmt.Sscanf(title[open:], "(constant %d, locals %d, params %d)", &index, &locals, &params); err != nil {
+		return &Error{Line: number, Message: fmt.Sprintf("unknown header %q", line)}
+	}
+
+	a.inConst = false
+	return a.startFunction(number, []string{
+		strconv.Itoa(index),
+		fmt.Sprintf("locals=%d", locals),
+		fmt.Sprintf("params=%d", params),
+	})
+}
+
+// .fn <定数の番号> locals=N params=M
+func (a *assembler) startFunction(number int, args []string) error {
+	if len(args) == 0 {
+		return &Error{Line: number, Message: ".fn needs a constant index"}
+	}
+
+	index, err := strconv.Atoi(args[0])
+	if err != nil || index < 0 || index >= len(a.constants) {
+		return &Error{Line: number, Message: fmt.Sprintf("invalid constant index %q", args[0])}
+	}
+
+	fn, ok := a.constants[index].(*object.CompiledFunction)
+	if !ok {
+		return &Error{Line: number, Message: fmt.Sprintf("constant %d is not a function", index)}
+	}
+	if _, ok := a.functions[index]; ok {
+		return &Error{Line: number, Message: fmt.Sprintf("duplicate .fn %d", index)}
+	}
+
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		n, err := strconv.Atoi(value)
+		if !ok || err != nil || n < 0 {
+			return &Error{Line: number, Message: fmt.Sprintf("invalid attribute %q", arg)}
+		}
+
+		switch key {
+		case "locals":
+			fn.NumLocals = n
+		case "params":
+			fn.NumParameters = n
+		default:
+			return &Error{Line: number, Message: fmt.Sprintf("unknown attribute %q", key)}
+		}
+	}
+
+	s := newSection()
+	a.functions[index] = s
+	a.current = s
+	return nil
+}
+
+// [番号:] 型 値
+func (a *assembler) readConstant(number int, line string) error {
+	if head, rest, ok := strings.Cut(line, ":"); ok && isNumber(head) {
+		if index, _ := strconv.Atoi(head); index != len(a.constants) {
+			return &Error{Line: number, Message: fmt.Sprintf("constant index %d out of order, expected %d", index, len(a.constants))}
+		}
+		line = strings.TrimSpace(rest)
+	}
+
+	kind, value, _ := strings.Cut(line, " ")
+	value = strings.TrimSpace(value)
+
+	var obj object.Object
+	switch kind {
+	case string(object.INTEGER_OBJ):
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return &Error{Line: number, Message: fmt.Sprintf("invalid integer %q", value)}
+		}
+		obj = &object.Integer{Value: n}
+	case string(object.FLOAT_OBJ):
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return &Error{Line: number, Message: fmt.Sprintf("invalid float %q", value)}
+		}
+		obj = &object.Float{Value: f}
+	case string(object.STRING_OBJ):
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return &Error{Line: number, Message: fmt.Sprintf("invalid string %s", value)}
+		}
+		obj = &object.String{Value: s}
+	case "fn":
+		name := value
+		if name == "<anonymous>" {
+			name = ""
+		}
+		obj = &object.CompiledFunction{Name: name}
+	default:
+		return &Error{Line: number, Message: fmt.Sprintf("unknown constant type %q", kind)}
+	}
+
+	a.constants = append(a.constants, obj)
+	a.constLines = append(a.constLines, number)
+	return nil
+}
+
+// 命令の行を覚えておく ラベルの位置を決めるために、ここでは命令の長さだけ数える
+func (s *section) add(number int, fields []string) error {
+	if isNumber(fields[0]) {
+		fields = fields[1:]
+		if len(fields) == 0 {
+			return nil
+		}
+	}
+
+	if label, ok := strings.CutSuffix(fields[0], ":"); ok {
+		if _, dup := s.labels[label]; dup {
+			return &Error{Line: number, Message: fmt.Sprintf("duplicate label %s", label)}
+		}
+		s.labels[label] = s.size
+		fields = fields[1:]
+		if len(fields) == 0 {
+			return nil
+		}
+	}
+
+	var width int
+	if fields[0] == ".byte" {
+		width = len(fields) - 1
+	} else {
+		op, ok := opcodes[fields[0]]
+		if !ok {
+			return &Error{Line: number, Message: fmt.Sprintf("unknown instruction %s", fields[0])}
+		}
+		def, _ := code.Lookup(byte(op))
+		width = def.Width()
+	}
+
+	s.lines = append(s.lines, sourceLine{number: number, fields: fields})
+	s.size += width
+	return nil
+}
+
+// ラベルの位置が全部決まってから命令を組み立てる
+func (s *section) assemble() (code.Instructions, error) {
+	ins := code.Instructions{}
+
+	for _, line := range s.lines {
+		if line.fields[0] == ".byte" {
+			for _, f := range line.fields[1:] {
+				b, err := strconv.ParseUint(f, 0, 8)
+				if err != nil {
+					return nil, &Error{Line: line.number, Message: fmt.Sprintf("invalid byte %q", f)}
+				}
+				ins = append(ins, byte(b))
+			}
+			continue
+		}
+
+		op := opcodes[line.fields[0]]
+		def, _ := code.Lookup(byte(op))
+
+		args := line.fields[1:]
+		if len(args) != len(def.OperandWidths) {
+			return nil, &Error{Line: line.number, Message: fmt.Sprintf("%s takes %d operands, got %d",
+				def.Name, len(def.OperandWidths), len(args))}
+		}
+
+		operands := make([]int, len(args))
+		for i, arg := range args {
+			n, err := s.operand(arg, code.IsJump(op) && i == 0)
+			if err != nil {
+				return nil, &Error{Line: line.number, Message: err.Error()}
+			}
+
+			if max := 1<<(8*def.OperandWidths[i]) - 1; n < 0 || n > max {
+				return nil, &Error{Line: line.number, Message: fmt.Sprintf("operand %d of %s out of range 0..%d",
+					n, def.Name, max)}
+			}
+			operands[i] = n
+		}
+
+		ins = append(ins, code.Make(op, operands...)...)
+	}
+
+	return ins, nil
+}
+
+// 数値か、ジャンプ先ならラベル
+func (s *section) operand(arg string, jump bool) (int, error) {
+	if isNumber(arg) {
+		return strconv.Atoi(arg)
+	}
+
+	if !jump {
+		return 0, fmt.Errorf("invalid operand %q", arg)
+	}
+
+	offset, ok := s.labels[arg]
+	if !ok {
+		return 0, fmt.Errorf("undefined label %s", arg)
+	}
+	return offset, nil
+}
+
+// ; から行末までを取り除く 文字列の中の ; はそのまま
+func stripComment(line string) string {
+	inString := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if inString {
+				i++
+			}
+		case '"':
+			inString = !inString
+		case ';':
+			if !inString {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,233 @@
+package asm
+
+import (
+	"bytes"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/disasm"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func concat(ins ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, i := range ins {
+		out = append(out, i...)
+	}
+	return out
+}
+
+func TestAssemble(t *testing.T) {
+	input := `
+; 10 を数え下げて、0 になったら double(21) を返す
+.const
+   0: INTEGER 10
+   1: INTEGER 1
+   2: STRING "done; really"
+   3: fn double
+   4: INTEGER 21
+   5: INTEGER 0
+
+.main
+     OpConstant 0
+     OpSetGlobal 0
+loop:
+     OpGetGlobal 0
+     OpConstant 5             ; 0 は偽ではないので比べる
+     OpGreaterThan
+     OpJumpNotTruthy exit
+     OpGetGlobal 0
+     OpConstant 1
+     OpSub
+     OpSetGlobal 0
+     OpJump loop
+exit:
+     OpClosure 3 0
+     OpConstant 4
+     OpCall 1
+     OpPop
+
+.fn 3 locals=1 params=1
+     OpGetLocal 0
+     OpGetLocal 0
+     OpAdd
+     OpReturnValue
+`
+
+	bytecode, err := Assemble(input)
+	if err != nil {
+		t.Fatalf("assemble error: %s", err)
+	}
+
+	expected := concat(
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpSetGlobal, 0),
+		// 0006
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpConstant, 5),
+		code.Make(code.OpGreaterThan),
+		code.Make(code.OpJumpNotTruthy, 29),
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpSub),
+		code.Make(code.OpSetGlobal, 0),
+		code.Make(code.OpJump, 6),
+		// 0029
+		code.Make(code.OpClosure, 3, 0),
+		code.Make(code.OpConstant, 4),
+		code.Make(code.OpCall, 1),
+		code.Make(code.OpPop),
+	)
+
+	if !bytes.Equal(bytecode.Instructions, expected) {
+		t.Errorf("wrong instructions.\nwant=%s\ngot =%s", expected, bytecode.Instructions)
+	}
+
+	if s, ok := bytecode.Constants[2].(*object.String); !ok || s.Value != "done; really" {
+		t.Errorf("wrong string constant. got=%+v", bytecode.Constants[2])
+	}
+
+	fn, ok := bytecode.Constants[3].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 3 is not a function. got=%T", bytecode.Constants[3])
+	}
+	if fn.Name != "double" || fn.NumLocals != 1 || fn.NumParameters != 1 {
+		t.Errorf("wrong function. got=%+v", fn)
+	}
+
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if got := machine.LastPoppedStackElem().Inspect(); got != "42" {
+		t.Errorf("wrong result. want=42, got=%s", got)
+	}
+}
+
+// code.Instructions.String() の出力を組み立て直すと元に戻る
+func TestInstructionsStringRoundTrip(t *testing.T) {
+	tests := []code.Instructions{
+		concat(code.Make(code.OpAdd), code.Make(code.OpGetLocal, 1), code.Make(code.OpConstant, 65535)),
+		concat(code.Make(code.OpClosure, 65535, 255), code.Make(code.OpIterNext, 12, 2), code.Make(code.OpPop)),
+		concat(code.Make(code.OpJumpTruthy, 0), code.Make(code.OpJump, 3), code.Make(code.OpShiftRight)),
+	}
+
+	for _, ins := range tests {
+		bytecode, err := Assemble(ins.String())
+		if err != nil {
+			t.Fatalf("assemble error: %s\n%s", err, ins)
+		}
+
+		if !bytes.Equal(bytecode.Instructions, ins) {
+			t.Errorf("round trip changed instructions.\nwant=%s\ngot =%s", ins, bytecode.Instructions)
+		}
+	}
+}
+
+// ソースマップは文字にならないので比べない
+func clearSourceMaps(bytecode *compiler.Bytecode) {
+	bytecode.SourceMap = nil
+	for _, c := range bytecode.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			fn.SourceMap = nil
+		}
+	}
+}
+
+func run(bytecode *compiler.Bytecode) string {
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		return "ERROR: " + err.Error()
+	}
+	if result := machine.LastPoppedStackElem(); result != nil {
+		return result.Inspect()
+	}
+	return ""
+}
+
+// 差分テストの全プログラムを逆アセンブルして組み立て直す
+func TestDisassemblyRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "harness", "testdata", "*.mk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		input, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := parser.New(lexer.New(string(input)))
+		program := p.ParseProgram()
+		comp := compiler.New()
+		if len(p.Errors()) != 0 || comp.Compile(program) != nil {
+			continue
+		}
+
+		original := comp.Bytecode()
+		text := disasm.Disassemble(original)
+
+		assembled, err := Assemble(text)
+		if err != nil {
+			t.Errorf("%s: assemble error: %s\n%s", file, err, text)
+			continue
+		}
+
+		// 位置の付かないエラーメッセージ同士で比べる
+		clearSourceMaps(original)
+		want := run(original)
+		if !reflect.DeepEqual(original, assembled) {
+			t.Errorf("%s: round trip changed bytecode.\n%s", file, text)
+		}
+
+		if got := run(assembled); got != want {
+			t.Errorf("%s: wrong result. want=%q, got=%q", file, want, got)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"OpFoo", "line 1: unknown instruction OpFoo"},
+		{"OpConstant", "line 1: OpConstant takes 1 operands, got 0"},
+		{"OpPop\nOpGetLocal 256", "line 2: operand 256 of OpGetLocal out of range 0..255"},
+		{"OpJump nowhere", "line 1: undefined label nowhere"},
+		{"OpConstant start", "line 1: invalid operand \"start\""},
+		{"a:\nOpPop\na:", "line 3: duplicate label a"},
+		{".byte 256", "line 1: invalid byte \"256\""},
+		{".const\nINTEGER x", "line 2: invalid integer \"x\""},
+		{".const\nSTRING \"abc", "line 2: invalid string \"abc"},
+		{".const\nBOOLEAN true", "line 2: unknown constant type \"BOOLEAN\""},
+		{".const\n1: INTEGER 1", "line 2: constant index 1 out of order, expected 0"},
+		{".const\nINTEGER 1\n.fn 0", "line 3: constant 0 is not a function"},
+		{".const\nfn f\n.fn 1", "line 3: invalid constant index \"1\""},
+		{".const\nfn f\n.fn 0 stack=3", "line 3: unknown attribute \"stack\""},
+		{".const\nfn f\n.fn 0\n.fn 0", "line 4: duplicate .fn 0"},
+		{".const\nINTEGER 1\nfn f", "line 3: constant 1 has no .fn section"},
+		{"== stack ==", "line 1: unknown header \"== stack ==\""},
+		{"== fn f (constant x) ==", "line 1: unknown header \"== fn f (constant x) ==\""},
+		{"== constants ==\nfn f\n== fn f (constant 1, locals 0, params 0) ==", "line 3: invalid constant index \"1\""},
+	}
+
+	for _, tt := range tests {
+		_, err := Assemble(tt.input)
+		if err == nil {
+			t.Errorf("expected error for %q", tt.input)
+			continue
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error for %q.\nwant=%q\ngot =%q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/disasm"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/mkc"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/repl"
+	"monkey/verifier"
+	"monkey/vm"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// 終了コード
+// シェルから失敗の種類を見分けられるように段階ごとに分ける
+const (
+	ExitOK           = 0
+	ExitRuntimeError = 1
+	ExitUsage        = 2
+	ExitParseError   = 3
+	ExitCompileError = 4
+	ExitBadBytecode  = 5 // .mkc が壊れているか、検査に通らない
+)
+
+const usage = `usage: monkey [-engine vm|eval] [-session file.mks] <command> [arguments]
+
+commands:
+  run <file>       run a script or a compiled .mkc file ('-' reads from stdin)
+  eval -e <code>   run code given on the command line and print the result
+  compile [-o out.mkc] <file>
+                   compile a script to a .mkc bytecode file
+  disasm <file>    print the bytecode of a script or a .mkc file
+  repl             start the interactive prompt (default)
+                   with -session, restore the session from the file
+                   and save it back on exit
+`
+
+// コマンドラインの実行1回分
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	// 実行方式 vm: コンパイラ + VM, eval: 評価器
+	engine string
+	// REPLのセッションを読み込み、終了時に保存するファイル
+	session string
+}
+
+// argsはプログラム名を除いた引数 戻り値は終了コード
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	defer object.SetOutput(object.SetOutput(stdout))
+
+	flags := flag.NewFlagSet("monkey", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { io.WriteString(stderr, usage) }
+	flags.StringVar(&c.engine, "engine", "vm", "use 'vm' or 'eval'")
+	flags.StringVar(&c.session, "session", "", "REPL session file")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if c.engine != "vm" && c.engine != "eval" {
+		fmt.Fprintf(stderr, "monkey: unknown engine: %s\n", c.engine)
+		return ExitUsage
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		return c.repl()
+	}
+
+	switch args[0] {
+	case "run":
+		return c.run(args[1:])
+	case "eval":
+		return c.eval(args[1:])
+	case "compile":
+		return c.compile(args[1:])
+	case "disasm":
+		return c.disasm(args[1:])
+	case "repl":
+		return c.repl()
+	case "help":
+		io.WriteString(stdout, usage)
+		return ExitOK
+	default:
+		fmt.Fprintf(stderr, "monkey: unknown command %q\n", args[0])
+		io.WriteString(stderr, usage)
+		return ExitUsage
+	}
+}
+
+func (c *cli) run(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(c.stderr, "usage: monkey run <file>\n")
+		return ExitUsage
+	}
+
+	name, data, err := c.readInput(args[0])
+	if err != nil {
+		fmt.Fprintf(c.stderr, "monkey: %s\n", err)
+		return ExitUsage
+	}
+
+	if !mkc.IsMKC(data) {
+		_, status := c.execute(name, stripShebang(string(data)))
+		return status
+	}
+
+	if c.engine == "eval" {
+		fmt.Fprintf(c.stderr, "monkey: %s: compiled bytecode cannot run on the eval engine\n", name)
+		return ExitUsage
+	}
+
+	bytecode, status := c.decode(name, data)
+	if status != ExitOK {
+		return status
+	}
+
+	// ファイルから読んだバイトコードは、VMに渡す前に検査する
+	if _, err := verifier.Verify(bytecode); err != nil {
+		fmt.Fprintf(c.stderr, "monkey: %s: invalid bytecode: %s\n", name, err)
+		return ExitBadBytecode
+	}
+
+	_, status = c.runBytecode(bytecode)
+	return status
+}
+
+func (c *cli) eval(args []string) int {
+	flags := flag.NewFlagSet("eval", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	expr := flags.String("e", "", "code to run")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if *expr == "" || flags.NArg() != 0 {
+		fmt.Fprintf(c.stderr, "usage: monkey eval -e <code>\n")
+		return ExitUsage
+	}
+
+	result, status := c.execute("<eval>", *expr)
+	if status == ExitOK && result != nil {
+		io.WriteString(c.stdout, result.Inspect())
+		io.WriteString(c.stdout, "\n")
+	}
+	return status
+}
+
+// 出力先を省略したら、入力のファイル名の拡張子を .mkc に変えたものに書く
+func (c *cli) compile(args []string) int {
+	flags := flag.NewFlagSet("compile", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	output := flags.String("o", "", "output file ('-' writes to stdout)")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(c.stderr, "usage: monkey compile [-o out.mkc] <file>\n")
+		return ExitUsage
+	}
+
+	path := flags.Arg(0)
+	if *output == "" {
+		*output = "-"
+		if path != "-" {
+			*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".mkc"
+		}
+	}
+
+	bytecode, status := c.load(path)
+	if status != ExitOK {
+		return status
+	}
+
+	var buf bytes.Buffer
+	if err := mkc.Encode(&buf, bytecode); err != nil {
+		fmt.Fprintf(c.stderr, "monkey: %s\n", err)
+		return ExitCompileError
+	}
+
+	if *output == "-" {
+		c.stdout.Write(buf.Bytes())
+		return ExitOK
+	}
+
+	if err := os.WriteFile(*output, buf.Bytes(), 0o644); err != nil {
+		fmt.Fprintf(c.stderr, "monkey: %s\n", err)
+		return ExitUsage
+	}
+	return ExitOK
+}
+
+func (c *cli) disasm(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(c.stderr, "usage: monkey disasm <file>\n")
+		return ExitUsage
+	}
+
+	bytecode, status := c.load(args[0])
+	if status != ExitOK {
+		return status
+	}
+
+	io.WriteString(c.stdout, disasm.Disassemble(bytecode))
+	return ExitOK
+}
+
+func (c *cli) repl() int {
+	if c.session != "" && c.engine == "eval" {
+		fmt.Fprintf(c.stderr, "monkey: -session requires the vm engine\n")
+		return ExitUsage
+	}
+
+	if u, err := user.Current(); err == nil {
+		fmt.Fprintf(c.stdout, "Hello %s! This is the Monkey programing language!\n", u.Username)
+	}
+	fmt.Fprintf(c.stdout, "Feel free to type in commands\n")
+
+	if c.session != "" {
+		if err := repl.StartSession(c.stdin, c.stdout, c.session); err != nil {
+			fmt.Fprintf(c.stderr, "monkey: %s\n", err)
+			return ExitUsage
+		}
+	} else if c.engine == "eval" {
+		repl.StartEval(c.stdin, c.stdout)
+	} else {
+		repl.Start(c.stdin, c.stdout)
+	}
+	return ExitOK
+}
+
+// ファイルか、 "-" なら標準入力を読む
+func (c *cli) readInput(path string) (string, []byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	name := path
+	if path == "-" {
+		name = "<stdin>"
+		data, err = io.ReadAll(c.stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return name, data, nil
+}
+
+// ソースならコンパイルし、 .mkc ならそのまま読み込んでバイトコードにする
+func (c *cli) load(path string) (*compiler.Bytecode, int) {
+	name, data, err := c.readInput(path)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "monkey: %s\n", err)
+		return nil, ExitUsage
+	}
+
+	if mkc.IsMKC(data) {
+		return c.decode(name, data)
+	}
+
+	program, status := c.parse(name, stripShebang(string(data)))
+	if status != ExitOK {
+		return nil, status
+	}
+
+	return c.compileProgram(program)
+}
+
+// #!/usr/bin/env monkey run の行を空行にする
+// 行を消すと位置がずれるので改行は残す
+func stripShebang(src string) string {
+	if !strings.HasPrefix(src, "#!") {
+		return src
+	}
+
+	if i := strings.IndexByte(src, '\n'); i >= 0 {
+		return src[i:]
+	}
+	return ""
+}
+
+func (c *cli) decode(name string, data []byte) (*compiler.Bytecode, int) {
+	bytecode, err := mkc.Decode(bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintf(c.stderr, "monkey: %s: %s\n", name, err)
+		return nil, ExitBadBytecode
+	}
+	return bytecode, ExitOK
+}
+
+// 構文解析からコンパイル、実行までを行い、最後に評価された値を返す
+// 最後の文が式でもreturnでもなければ値はnil (VMには前の文の値が残っているので使わない)
+func (c *cli) execute(name, src string) (object.Object, int) {
+	program, status := c.parse(name, src)
+	if status != ExitOK {
+		return nil, status
+	}
+
+	result, status := c.executeProgram(name, program)
+	if status != ExitOK || !endsWithValue(program) {
+		return nil, status
+	}
+	return result, ExitOK
+}
+
+func endsWithValue(program *ast.Program) bool {
+	if len(program.Statements) == 0 {
+		return false
+	}
+
+	switch program.Statements[len(program.Statements)-1].(type) {
+	case *ast.ExpressionStatement, *ast.ReturnStatement:
+		return true
+	}
+	return false
+}
+
+func (c *cli) executeProgram(name string, program *ast.Program) (object.Object, int) {
+	if c.engine == "eval" {
+		env := object.NewEnviroment()
+		result := evaluator.Eval(program, env)
+		if errObj, ok := result.(*object.Error); ok {
+			fmt.Fprintf(c.stderr, "%s: %s\n", name, errObj.Inspect())
+			return nil, ExitRuntimeError
+		}
+		return result, ExitOK
+	}
+
+	bytecode, status := c.compileProgram(program)
+	if status != ExitOK {
+		return nil, status
+	}
+
+	return c.runBytecode(bytecode)
+}
+
+func (c *cli) runBytecode(bytecode *compiler.Bytecode) (object.Object, int) {
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		fmt.Fprintf(c.stderr, "runtime error: %s\n", err)
+		if runtimeErr, ok := err.(*vm.RuntimeError); ok {
+			io.WriteString(c.stderr, runtimeErr.StackTrace())
+		}
+		return nil, ExitRuntimeError
+	}
+
+	return machine.LastPoppedStackElem(), ExitOK
+}
+
+func (c *cli) parse(name, src string) (*ast.Program, int) {
+	l := lexer.NewWithFile(name, src)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		for _, d := range p.Diagnostics() {
+			fmt.Fprintf(c.stderr, "%s\n", d)
+		}
+		return nil, ExitParseError
+	}
+
+	return program, ExitOK
+}
+
+// エラーは1つずつではなく、見つかったものを全部表示する
+func (c *cli) compileProgram(program *ast.Program) (*compiler.Bytecode, int) {
+	comp := compiler.New()
+	comp.CollectErrors(true)
+
+	if err := comp.Compile(program); err != nil {
+		if list, ok := err.(compiler.ErrorList); ok {
+			for _, e := range list {
+				fmt.Fprintf(c.stderr, "compile error: %s\n", e)
+			}
+		} else {
+			fmt.Fprintf(c.stderr, "compile error: %s\n", err)
+		}
+		return nil, ExitCompileError
+	}
+
+	return comp.Bytecode(), ExitOK
+}
@@ -0,0 +1,207 @@
+package cli
+
+import (
+	"bytes"
+	"monkey/asm"
+	"monkey/mkc"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.mk")
+	src := "#!/usr/bin/env monkey run\nlet x = 1;\nx + y;\n"
+	if err := os.WriteFile(script, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args           []string
+		stdin          string
+		expectedStatus int
+		expectedStdout string
+		expectedStderr string
+	}{
+		{[]string{"eval", "-e", "1 + 2 * 3"}, "", ExitOK, "7\n", ""},
+		{[]string{"-engine", "eval", "eval", "-e", "len(\"abc\")"}, "", ExitOK, "3\n", ""},
+		{[]string{"eval", "-e", "let = 1;"}, "", ExitParseError, "",
+			"<eval>:1:5: error[P001]: expected next token to be IDENT, got = instead"},
+		{[]string{"eval", "-e", "a + b"}, "", ExitCompileError, "",
+			"compile error: <eval>:1:1: undefined variable a\ncompile error: <eval>:1:5: undefined variable b\n"},
+		{[]string{"eval", "-e", "1 / 0"}, "", ExitRuntimeError, "",
+			"runtime error: <eval>:1:3: division by zero"},
+		{[]string{"-engine", "eval", "eval", "-e", "1 / 0"}, "", ExitRuntimeError, "",
+			"<eval>: ERROR: division by zero\n"},
+		{[]string{"eval", "-e", "1; let x = 2;"}, "", ExitOK, "", ""},
+		{[]string{"-engine", "eval", "eval", "-e", "1; let x = 2;"}, "", ExitOK, "", ""},
+		{[]string{"eval", "-e", "let x = 2; while (x > 0) { x -= 1; }"}, "", ExitOK, "", ""},
+		{[]string{"eval", "-e", "let x = 2; return x * 3;"}, "", ExitOK, "6\n", ""},
+		{[]string{"eval", "-e", "len(1); 5"}, "", ExitRuntimeError, "",
+			"runtime error: <eval>:1:4: argument to `len` not supported, got INTEGER"},
+		{[]string{"-engine", "eval", "eval", "-e", "len(1); 5"}, "", ExitRuntimeError, "",
+			"<eval>: ERROR: argument to `len` not supported, got INTEGER\n"},
+		{[]string{"run", "-"}, "let a = 5; a * 2", ExitOK, "", ""},
+		{[]string{"run", "-"}, "puts(\"hi\", 1)", ExitOK, "hi\n1\n", ""},
+		{[]string{"-engine", "eval", "run", "-"}, "puts(\"hi\")", ExitOK, "hi\n", ""},
+		{[]string{"run", "-"}, "#!/usr/bin/env monkey run\n1 +", ExitParseError, "",
+			"<stdin>:2:4: error[P002]"},
+		{[]string{"run", script}, "", ExitCompileError, "",
+			script + ":3:5: undefined variable y"},
+		{[]string{"run", "-"}, mkc.Magic + "\x00", ExitBadBytecode, "", "<stdin>: mkc: truncated file"},
+		{[]string{"compile", "-o", "-", "-"}, "let = 1;", ExitParseError, "",
+			"<stdin>:1:5: error[P001]"},
+		{[]string{"compile", "-o", filepath.Join(dir, "script.mkc"), script}, "", ExitCompileError, "",
+			script + ":3:5: undefined variable y"},
+		{[]string{"compile", "-o", "-", "-"}, mkc.Magic + "\x00", ExitBadBytecode, "", "<stdin>: mkc: truncated file"},
+		{[]string{"compile"}, "", ExitUsage, "", "usage: monkey compile [-o out.mkc] <file>"},
+		{[]string{"run", filepath.Join(dir, "missing.mk")}, "", ExitUsage, "", "no such file"},
+		{[]string{"run"}, "", ExitUsage, "", "usage: monkey run <file>"},
+		{[]string{"eval"}, "", ExitUsage, "", "usage: monkey eval -e <code>"},
+		{[]string{"frobnicate"}, "", ExitUsage, "", "unknown command \"frobnicate\""},
+		{[]string{"-engine", "jit", "run", "-"}, "", ExitUsage, "", "unknown engine: jit"},
+		{[]string{"-engine", "eval", "-session", filepath.Join(dir, "s.mks"), "repl"}, "", ExitUsage, "",
+			"-session requires the vm engine"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		status := Run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+		if status != tt.expectedStatus {
+			t.Errorf("%q: wrong exit status. want=%d, got=%d (stderr=%q)",
+				tt.args, tt.expectedStatus, status, stderr.String())
+		}
+
+		if stdout.String() != tt.expectedStdout {
+			t.Errorf("%q: wrong stdout. want=%q, got=%q", tt.args, tt.expectedStdout, stdout.String())
+		}
+
+		if tt.expectedStderr == "" && stderr.Len() != 0 {
+			t.Errorf("%q: unexpected stderr %q", tt.args, stderr.String())
+		}
+
+		if !strings.Contains(stderr.String(), tt.expectedStderr) {
+			t.Errorf("%q: stderr does not contain %q. got=%q", tt.args, tt.expectedStderr, stderr.String())
+		}
+	}
+}
+
+func TestCompileAndRunMKC(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.mk")
+	src := "#!/usr/bin/env monkey run\nlet f = fn(a) { a / 0 };\nf(1)\n"
+	if err := os.WriteFile(script, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if status := Run([]string{"compile", script}, nil, &stdout, &stderr); status != ExitOK {
+		t.Fatalf("compile failed. status=%d, stderr=%q", status, stderr.String())
+	}
+
+	compiled := filepath.Join(dir, "script.mkc")
+	stderr.Reset()
+	status := Run([]string{"run", compiled}, nil, &stdout, &stderr)
+	if status != ExitRuntimeError {
+		t.Fatalf("wrong exit status. want=%d, got=%d (stderr=%q)", ExitRuntimeError, status, stderr.String())
+	}
+
+	// 保存したバイトコードにもソース上の位置が残っている
+	expected := "runtime error: " + script + ":2:19: division by zero (OpDiv at 0005)\n" +
+		"\tat f (" + script + ":2:19)\n" +
+		"\tat <main> (" + script + ":3:2)\n"
+	if stderr.String() != expected {
+		t.Errorf("wrong stderr.\nwant=%q\ngot =%q", expected, stderr.String())
+	}
+
+	data, err := os.ReadFile(compiled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stderr.Reset()
+	status = Run([]string{"run", "-"}, bytes.NewReader(data[:len(data)-1]), &stdout, &stderr)
+	if status != ExitBadBytecode || !strings.Contains(stderr.String(), "<stdin>: mkc: truncated file") {
+		t.Errorf("truncated file was not rejected. status=%d, stderr=%q", status, stderr.String())
+	}
+
+	stderr.Reset()
+	status = Run([]string{"-engine", "eval", "run", compiled}, nil, &stdout, &stderr)
+	if status != ExitUsage {
+		t.Errorf("eval engine accepted bytecode. status=%d, stderr=%q", status, stderr.String())
+	}
+
+	if stdout.Len() != 0 {
+		t.Errorf("unexpected stdout %q", stdout.String())
+	}
+}
+
+func TestRunInvalidMKC(t *testing.T) {
+	// 定数表の外を指す命令
+	bytecode, err := asm.Assemble(".const\nINTEGER 1\n.main\nOpConstant 1\nOpPop")
+	if err != nil {
+		t.Fatalf("assemble error: %s", err)
+	}
+
+	var data bytes.Buffer
+	if err := mkc.Encode(&data, bytecode); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	status := Run([]string{"run", "-"}, bytes.NewReader(data.Bytes()), &stdout, &stderr)
+	if status != ExitBadBytecode {
+		t.Errorf("wrong exit status. want=%d, got=%d", ExitBadBytecode, status)
+	}
+
+	expected := "monkey: <stdin>: invalid bytecode: <main> at 0000: constant 1 out of range (pool size 1)\n"
+	if stderr.String() != expected {
+		t.Errorf("wrong stderr.\nwant=%q\ngot =%q", expected, stderr.String())
+	}
+
+	// 逆アセンブルは壊れたバイトコードでもできる
+	stdout.Reset()
+	stderr.Reset()
+	status = Run([]string{"disasm", "-"}, bytes.NewReader(data.Bytes()), &stdout, &stderr)
+	if status != ExitOK || !strings.Contains(stdout.String(), "OpConstant 1             ; constant 1 out of range") {
+		t.Errorf("disasm failed. status=%d, stdout=%q, stderr=%q", status, stdout.String(), stderr.String())
+	}
+}
+
+func TestDisasm(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	status := Run([]string{"disasm", "-"}, strings.NewReader("let add = fn(a, b) { a + b }; add(1, 2)"), &stdout, &stderr)
+	if status != ExitOK {
+		t.Fatalf("wrong exit status. got=%d (stderr=%q)", status, stderr.String())
+	}
+
+	for _, want := range []string{
+		"== main ==\n0000 OpClosure 0 0            ; fn add\n",
+		"== fn add (constant 0, locals 2, params 2) ==\n0000 OpGetLocal 0\n0002 OpGetLocal 1\n0004 OpAdd\n0005 OpReturnValue\n",
+		"   1: INTEGER 1\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("disassembly does not contain %q. got=\n%s", want, stdout.String())
+		}
+	}
+}
+
+func TestStripShebang(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"#!/usr/bin/env monkey run\nputs(1)", "\nputs(1)"},
+		{"#!/usr/bin/env monkey run", ""},
+		{"puts(1)\n#!", "puts(1)\n#!"},
+	}
+
+	for _, tt := range tests {
+		if got := stripShebang(tt.input); got != tt.expected {
+			t.Errorf("stripShebang(%q) wrong. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
@@ -16,11 +16,18 @@ func (ins Instructions) String() string {
 	for i < len(ins) {
 		def, err := Lookup(ins[i])
 
+		// 読めないバイトは1バイトずつ飛ばして続ける
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			fmt.Fprintf(&out, "\n\t%04d ERROR: %s", i, err)
+			i++
 			continue
 		}
 
+		if i+def.Width() > len(ins) {
+			fmt.Fprintf(&out, "\n\t%04d ERROR: %s is truncated", i, def.Name)
+			break
+		}
+
 		operands, read := ReadOperands(def, ins[i+1:])
 		fmt.Fprintf(&out, "\n\t%04d %s", i, ins.fmtInstuction(def, operands))
 
@@ -38,14 +45,13 @@ func (ins Instructions) fmtInstuction(def *Definition, operands []int) string {
 			len(operands), operandCount)
 	}
 
-	switch operandCount {
-	case 0:
-		return def.Name
-	case 1:
-		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	var out bytes.Buffer
+	out.WriteString(def.Name)
+	for _, o := range operands {
+		fmt.Fprintf(&out, " %d", o)
 	}
 
-	return fmt.Sprintf("ERROR: unhandled operand count for %s\n", def.Name)
+	return out.String()
 }
 
 type Opcode byte
@@ -77,6 +83,26 @@ const (
 	OpReturn
 	OpGetLocal
 	OpSetLocal
+	OpClosure
+	OpGetFree
+	OpCurrentClosure
+	OpPatchFree
+	OpGetBuiltin
+	OpIterInit
+	OpIterNext
+	OpSetIndex
+	OpDup2
+	OpDup
+	OpJumpTruthy
+	OpLessThan
+	OpLessEqual
+	OpGreaterEqual
+	OpMod
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShiftLeft
+	OpShiftRight
 )
 
 type Definition struct {
@@ -84,33 +110,74 @@ type Definition struct {
 	OperandWidths []int
 }
 
+// オペランドを含めた命令のバイト数
+func (def *Definition) Width() int {
+	width := 1
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+	return width
+}
+
+// 最初のオペランドがジャンプ先の命令位置になっている命令
+var jumpOpcodes = map[Opcode]bool{
+	OpJump:          true,
+	OpJumpNotTruthy: true,
+	OpJumpTruthy:    true,
+	OpIterNext:      true,
+}
+
+func IsJump(op Opcode) bool {
+	return jumpOpcodes[op]
+}
+
 var definitions = map[Opcode]*Definition{
-	OpConstant:      {"OpConstant", []int{2}},
-	OpAdd:           {"OpAdd", []int{}},
-	OpPop:           {"OpPop", []int{}},
-	OpSub:           {"OpSub", []int{}},
-	OpMul:           {"OpMul", []int{}},
-	OpDiv:           {"OpDiv", []int{}},
-	OpTrue:          {"OpTrue", []int{}},
-	OpFalse:         {"OpFalse", []int{}},
-	OpEqual:         {"OpEqual", []int{}},
-	OpNotEqual:      {"OpNotEqual", []int{}},
-	OpGreaterThan:   {"OpGreaterThan", []int{}},
-	OpMinus:         {"OpMinus", []int{}},
-	OpBang:          {"OpBang", []int{}},
-	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
-	OpJump:          {"OpJump", []int{2}},
-	OpNull:          {"OpNull", []int{}},
-	OpGetGlobal:     {"OpGetGlobal", []int{2}},
-	OpSetGlobal:     {"OpSetGlobal", []int{2}},
-	OpArray:         {"OpArray", []int{2}},
-	OpHash:          {"OpHash", []int{2}},
-	OpIndex:         {"OpIndex", []int{}},
-	OpCall:          {"OpCall", []int{}},
-	OpReturnValue:   {"OpReturnValue", []int{}},
-	OpReturn:        {"OpReturn", []int{}},
-	OpGetLocal:      {"OpGetLocal", []int{1}},
-	OpSetLocal:      {"OpSetLocal", []int{1}},
+	OpConstant:       {"OpConstant", []int{2}},
+	OpAdd:            {"OpAdd", []int{}},
+	OpPop:            {"OpPop", []int{}},
+	OpSub:            {"OpSub", []int{}},
+	OpMul:            {"OpMul", []int{}},
+	OpDiv:            {"OpDiv", []int{}},
+	OpTrue:           {"OpTrue", []int{}},
+	OpFalse:          {"OpFalse", []int{}},
+	OpEqual:          {"OpEqual", []int{}},
+	OpNotEqual:       {"OpNotEqual", []int{}},
+	OpGreaterThan:    {"OpGreaterThan", []int{}},
+	OpMinus:          {"OpMinus", []int{}},
+	OpBang:           {"OpBang", []int{}},
+	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
+	OpJump:           {"OpJump", []int{2}},
+	OpNull:           {"OpNull", []int{}},
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpArray:          {"OpArray", []int{2}},
+	OpHash:           {"OpHash", []int{2}},
+	OpIndex:          {"OpIndex", []int{}},
+	OpCall:           {"OpCall", []int{1}},
+	OpReturnValue:    {"OpReturnValue", []int{}},
+	OpReturn:         {"OpReturn", []int{}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpClosure:        {"OpClosure", []int{2, 1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpPatchFree:      {"OpPatchFree", []int{1}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpIterInit:       {"OpIterInit", []int{}},
+	OpIterNext:       {"OpIterNext", []int{2, 1}},
+	OpSetIndex:       {"OpSetIndex", []int{}},
+	OpDup2:           {"OpDup2", []int{}},
+	OpDup:            {"OpDup", []int{}},
+	OpJumpTruthy:     {"OpJumpTruthy", []int{2}},
+	OpLessThan:       {"OpLessThan", []int{}},
+	OpLessEqual:      {"OpLessEqual", []int{}},
+	OpGreaterEqual:   {"OpGreaterEqual", []int{}},
+	OpMod:            {"OpMod", []int{}},
+	OpBitAnd:         {"OpBitAnd", []int{}},
+	OpBitOr:          {"OpBitOr", []int{}},
+	OpBitXor:         {"OpBitXor", []int{}},
+	OpShiftLeft:      {"OpShiftLeft", []int{}},
+	OpShiftRight:     {"OpShiftRight", []int{}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -128,11 +195,7 @@ func Make(op Opcode, operands ...int) []byte {
 		return []byte{}
 	}
 
-	instrctionLen := 1
-
-	for _, w := range def.OperandWidths {
-		instrctionLen += w
-	}
+	instrctionLen := def.Width()
 
 	instrction := make([]byte, instrctionLen)
 	instrction[0] = byte(op)
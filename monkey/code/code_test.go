@@ -1,6 +1,7 @@
 package code
 
 import (
+	"monkey/token"
 	"testing"
 )
 
@@ -20,6 +21,11 @@ func TestReardOperands(t *testing.T) {
 			[]int{255},
 			1,
 		},
+		{
+			OpClosure,
+			[]int{65535, 255},
+			3,
+		},
 	}
 
 	for _, tt := range tests {
@@ -52,13 +58,15 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpGetLocal, 1),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
 	}
 
 	expected := `
 	0000 OpAdd
 	0001 OpGetLocal 1
 	0003 OpConstant 2
-	0006 OpConstant 65535`
+	0006 OpConstant 65535
+	0009 OpClosure 65535 255`
 
 	concatted := Instructions{}
 
@@ -92,6 +100,11 @@ func TestMake(t *testing.T) {
 			[]int{255},
 			[]byte{byte(OpGetLocal), 255},
 		},
+		{
+			OpClosure,
+			[]int{65534, 255},
+			[]byte{byte(OpClosure), 255, 254, 255},
+		},
 	}
 
 	for _, tt := range tests {
@@ -109,3 +122,54 @@ func TestMake(t *testing.T) {
 		}
 	}
 }
+
+func TestSourceMapLookup(t *testing.T) {
+	sm := SourceMap{
+		{Offset: 0, Pos: token.Pos{Line: 1, Column: 1}},
+		{Offset: 3, Pos: token.Pos{Line: 1, Column: 5}},
+		{Offset: 7, Pos: token.Pos{Line: 2, Column: 1}},
+	}
+
+	tests := []struct {
+		offset   int
+		expected token.Pos
+		ok       bool
+	}{
+		{-1, token.Pos{}, false},
+		{0, token.Pos{Line: 1, Column: 1}, true},
+		{2, token.Pos{Line: 1, Column: 1}, true},
+		{3, token.Pos{Line: 1, Column: 5}, true},
+		{6, token.Pos{Line: 1, Column: 5}, true},
+		{100, token.Pos{Line: 2, Column: 1}, true},
+	}
+
+	for _, tt := range tests {
+		pos, ok := sm.Lookup(tt.offset)
+		if ok != tt.ok || pos != tt.expected {
+			t.Errorf("Lookup(%d) wrong. want=(%+v, %t), got=(%+v, %t)",
+				tt.offset, tt.expected, tt.ok, pos, ok)
+		}
+	}
+}
+
+func TestMalformedInstructionsString(t *testing.T) {
+	tests := []struct {
+		ins      Instructions
+		expected string
+	}{
+		{
+			Instructions{255, byte(OpAdd)},
+			"\n\t0000 ERROR: opcode 255 undefined\n\t0001 OpAdd",
+		},
+		{
+			append(Make(OpPop), byte(OpConstant), 1),
+			"\n\t0000 OpPop\n\t0001 ERROR: OpConstant is truncated",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.ins.String(); got != tt.expected {
+			t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package code
+
+import "monkey/token"
+
+// 命令の位置からソース上の位置を引くための表
+// 命令を出した順に追加するので、Offsetは昇順に並ぶ
+type SourceMap []SourceMapEntry
+
+// Offsetの命令から、次のエントリの直前までがPosに対応する
+type SourceMapEntry struct {
+	Offset int
+	Pos    token.Pos
+}
+
+// offsetにある命令のソース上の位置を返す
+// 命令の途中(オペランド)を指していても、その命令の位置を返す
+func (sm SourceMap) Lookup(offset int) (token.Pos, bool) {
+	found := -1
+	for i, entry := range sm {
+		if entry.Offset > offset {
+			break
+		}
+		found = i
+	}
+
+	if found < 0 {
+		return token.Pos{}, false
+	}
+
+	return sm[found].Pos, true
+}
@@ -1,11 +1,10 @@
 package compiler
 
 import (
-	"fmt"
 	"monkey/ast"
 	"monkey/code"
 	"monkey/object"
-	"sort"
+	"monkey/token"
 )
 
 type EmittedInstruction struct {
@@ -17,6 +16,20 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	loops               []*LoopContext
+	sourceMap           code.SourceMap
+	// 式の途中で、まだ使われずにスタックに積まれている値の数
+	pending int
+}
+
+// コンパイル中のループ
+// breakのジャンプ先はループを抜けるまで決まらないので、後で書き換える位置を覚えておく
+type LoopContext struct {
+	continuePos  int
+	breakJumpPos []int
+	// ループに入ったときにスタックに積まれていた値の数
+	// 1 + if (c) { break; } のように式の途中で抜けるときは、それより上の値を捨ててから飛ぶ
+	pending int
 }
 
 type Compiler struct {
@@ -27,6 +40,20 @@ type Compiler struct {
 	symbolTable         *SymbolTable
 	scopes              []CompilationScope
 	scopeIndex          int
+
+	// 今コンパイルしているノードの位置 出した命令と対応づける
+	pos token.Pos
+
+	// trueなら最初のエラーで止まらず、エラーを溜めながら最後までコンパイルする
+	collectErrors bool
+	errors        ErrorList
+	// Compileの入れ子の深さ 0に戻るところで溜めたエラーを返す
+	depth int
+	// メインでのreturnが出したOpJumpの位置 一番外側のCompileの終わりで飛び先を埋める
+	mainReturns []int
+
+	// コンパイル中の文の並びごとの関数定義 内側の並びほど後ろ
+	forward []*forwardFunctions
 }
 
 func New() *Compiler {
@@ -36,12 +63,18 @@ func New() *Compiler {
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
 	}
+	symbolTable := NewSymbolTable()
+
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
 	return &Compiler{
 		instructions:        code.Instructions{},
 		constants:           []object.Object{},
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
-		symbolTable:         NewSymbolTable(),
+		symbolTable:         symbolTable,
 		scopes:              []CompilationScope{maminScope},
 		scopeIndex:          0,
 	}
@@ -54,14 +87,38 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	return compiler
 }
 
-func (c *Compiler) Compile(node ast.Node) error {
+func (c *Compiler) Compile(node ast.Node) (err error) {
+	prevPos := c.pos
+	if pos := sourcePos(node); pos.IsValid() {
+		c.pos = pos
+	}
+
+	c.depth++
+	defer func() {
+		c.pos = prevPos
+		c.depth--
+
+		if c.depth > 0 {
+			return
+		}
+
+		// 命令列の終わりが決まったので、メインのreturnの飛び先を埋める
+		for _, pos := range c.mainReturns {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+		c.mainReturns = nil
+
+		// 一番外側の呼び出しで、溜めておいたエラーをまとめて返す
+		if err == nil && len(c.errors) > 0 {
+			err = c.errors
+		}
+	}()
+
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
+		err := c.compileStatements(node.Statements)
+		if err != nil {
+			return err
 		}
 	case *ast.CallExpression:
 		err := c.Compile(node.Function)
@@ -69,41 +126,35 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		c.emit(code.OpCall)
-	case *ast.FunctionLiteral:
-		c.enterScope()
-
-		err := c.Compile(node.Body)
+		for i, a := range node.Arguments {
+			err := c.compileOver(1+i, a)
+			if err != nil {
+				return err
+			}
+		}
 
+		c.emit(code.OpCall, len(node.Arguments))
+	case *ast.FunctionLiteral:
+		_, err := c.compileFunctionLiteral(node)
 		if err != nil {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.replaceLastPopWithReturn()
-		}
-
-		if !c.lastInstructionIs(code.OpReturnValue) {
-			c.emit(code.OpReturn)
-		}
-
-		numLocals := c.symbolTable.numDefinitions
-		instructions := c.leaveScope()
-
-		compiledFn := &object.CompiledFunction{
-			Instructions: instructions,
-			NumLocals:    numLocals,
-		}
-
-		c.emit(code.OpConstant, c.addConstant(compiledFn))
-
 	case *ast.ReturnStatement:
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
 			return err
 		}
 
-		c.emit(code.OpReturnValue)
+		if c.scopeIndex > 0 {
+			c.emit(code.OpReturnValue)
+			break
+		}
+
+		// メインでのreturnはその値を最後の値にして、命令列の終わりへ飛ぶ
+		c.emit(code.OpPop)
+		pos := c.emit(code.OpJump, 9999)
+		c.mainReturns = append(c.mainReturns, pos)
 	case *ast.IndexExpression:
 		err := c.Compile(node.Left)
 
@@ -111,30 +162,21 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		err = c.Compile(node.Index)
+		err = c.compileOver(1, node.Index)
 		if err != nil {
 			return err
 		}
 
 		c.emit(code.OpIndex)
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, k := range keys {
-			err := c.Compile(k)
+		// ソースに書かれた順番で評価し、その順番でハッシュに追加する
+		for i, k := range node.Keys {
+			err := c.compileOver(2*i, k)
 			if err != nil {
 				return err
 			}
 
-			err = c.Compile(node.Pairs[k])
+			err = c.compileOver(2*i+1, node.Pairs[k])
 			if err != nil {
 				return err
 			}
@@ -142,8 +184,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpHash, len(node.Pairs)*2)
 	case *ast.ArrayLiteral:
-		for _, el := range node.Elements {
-			err := c.Compile(el)
+		for i, el := range node.Elements {
+			err := c.compileOver(i, el)
 			if err != nil {
 				return err
 			}
@@ -170,9 +212,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
+		c.keepBlockValue()
 
 		jumpPos := c.emit(code.OpJump, 9999)
 		afterConsequencePos := len(c.currentInstructions())
@@ -187,19 +227,103 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
+			c.keepBlockValue()
 		}
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 
+	case *ast.WhileStatement:
+		loop := c.enterLoop()
+
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		c.enterBlock()
+		err = c.Compile(node.Body)
+		c.leaveBlock()
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpJump, loop.continuePos)
+
+		afterLoopPos := len(c.currentInstructions())
+		c.changeOperand(jumpNotTruthyPos, afterLoopPos)
+		for _, pos := range loop.breakJumpPos {
+			c.changeOperand(pos, afterLoopPos)
+		}
+
+		c.leaveLoop()
+	case *ast.ForStatement:
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpIterInit)
+
+		// ループの変数は本体と同じブロックの中だけで見える
+		c.enterBlock()
+
+		// 反復子は名前のない変数にしまっておく
+		// ループ途中でbreakやreturnしてもスタックに何も残らない
+		iterator := c.symbolTable.Reserve()
+		c.storeSymbol(iterator)
+
+		loop := c.enterLoop()
+		c.loadSymbol(iterator)
+		iterNextPos := c.emit(code.OpIterNext, 9999, len(node.Variables))
+
+		symbols := make([]Symbol, len(node.Variables))
+		for i, v := range node.Variables {
+			symbols[i] = c.symbolTable.Define(v.Value)
+		}
+
+		// 最後に積まれた値から順に束縛する
+		for i := len(symbols) - 1; i >= 0; i-- {
+			c.storeSymbol(symbols[i])
+		}
+
+		err = c.Compile(node.Body)
+		c.leaveBlock()
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpJump, loop.continuePos)
+
+		afterLoopPos := len(c.currentInstructions())
+		c.changeOperand(iterNextPos, afterLoopPos, len(node.Variables))
+		for _, pos := range loop.breakJumpPos {
+			c.changeOperand(pos, afterLoopPos)
+		}
+
+		c.leaveLoop()
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return c.report(errorf(node, "break outside of loop"))
+		}
+
+		c.discardPending(loop)
+		pos := c.emit(code.OpJump, 9999)
+		loop.breakJumpPos = append(loop.breakJumpPos, pos)
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return c.report(errorf(node, "continue outside of loop"))
+		}
+
+		c.discardPending(loop)
+		c.emit(code.OpJump, loop.continuePos)
 	case *ast.BlockStatement:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
+		err := c.compileStatements(node.Statements)
+		if err != nil {
+			return err
 		}
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expression)
@@ -221,23 +345,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return c.report(errorf(node, "unknown operator %s", node.Operator))
 		}
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			err := c.Compile(node.Right)
-			if err != nil {
-				return err
-			}
-
-			err = c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
-
-			c.emit(code.OpGreaterThan)
-
-			return nil
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
 		}
 
 		err := c.Compile(node.Left)
@@ -245,30 +357,21 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		err = c.Compile(node.Right)
+		err = c.compileOver(1, node.Right)
 		if err != nil {
 			return err
 		}
 
-		switch node.Operator {
-		case "+":
-			c.emit(code.OpAdd)
-		case "-":
-			c.emit(code.OpSub)
-		case "*":
-			c.emit(code.OpMul)
-		case "/":
-			c.emit(code.OpDiv)
-		case ">":
-			c.emit(code.OpGreaterThan)
-		case "==":
-			c.emit(code.OpEqual)
-		case "!=":
-			c.emit(code.OpNotEqual)
-		default:
-			return fmt.Errorf("Unknown operator %s", node.Operator)
+		op, ok := infixOperators[node.Operator]
+		if !ok {
+			return c.report(errorf(node, "unknown operator %s", node.Operator))
 		}
 
+		c.emit(op)
+
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
+
 	case *ast.LetStatement:
 		err := c.Compile(node.Value)
 		if err != nil {
@@ -276,22 +379,14 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		symbol := c.symbolTable.Define(node.Name.Value)
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
-		}
+		c.storeSymbol(symbol)
 	case *ast.Identifier:
-		symbol, ok := c.symbolTable.Resolve(node.Value)
+		symbol, ok := c.resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return c.reportExpression(errorf(node, "undefined variable %s", node.Value))
 		}
 
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpGetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpGetLocal, symbol.Index)
-		}
+		c.loadSymbol(symbol)
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -304,11 +399,468 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{
+			Value: node.Value,
+		}
+
+		c.emit(code.OpConstant, c.addConstant(float))
+	}
+
+	return nil
+}
+
+// 関数リテラルをコンパイルし、捕捉した自由変数の元のシンボルを返却する
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) ([]Symbol, error) {
+	c.enterScope()
+
+	if node.Name != "" {
+		c.symbolTable.DefineFunctionName(node.Name)
+	}
+
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+
+	err := c.Compile(node.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	instructions := c.leaveScope()
+
+	// 捕捉する値を外側のスコープからスタックに積んでおく
+	for _, s := range freeSymbols {
+		c.loadSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		Name:          node.Name,
+		SourceMap:     sourceMap,
+	}
+
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	return freeSymbols, nil
+}
+
+func (c *Compiler) compileStatements(stmts []ast.Statement) error {
+	forward := &forwardFunctions{
+		symbolTable: c.symbolTable,
+		stmts:       stmts,
+		lets:        functionLets(stmts),
+		defined:     map[string]Symbol{},
+	}
+	c.forward = append(c.forward, forward)
+	defer func() { c.forward = c.forward[:len(c.forward)-1] }()
+
+	var compiled []compiledFunction
+
+	for i, s := range stmts {
+		forward.current = i
+
+		let, ok := s.(*ast.LetStatement)
+		if !ok || forward.lets[let.Name.Value] != let {
+			err := c.Compile(s)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		fn, err := c.compileFunctionLet(forward, let, compiled)
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, fn)
 	}
 
 	return nil
 }
 
+// 文の並びの中の関数定義
+// 関数の本体からは、同じ並びの後ろで定義される関数も呼び出せる
+type forwardFunctions struct {
+	symbolTable *SymbolTable
+	stmts       []ast.Statement
+	// 今コンパイルしている文の位置
+	current int
+	lets    map[string]*ast.LetStatement
+	// 定義より先に参照されたので、名前だけ先に定義したもの
+	defined map[string]Symbol
+	// この並びの関数定義をコンパイル中かどうか
+	inFunction bool
+}
+
+// コンパイル済みの関数定義と、それが捕捉した自由変数の元のシンボル
+type compiledFunction struct {
+	symbol Symbol
+	free   []Symbol
+}
+
+// let f = fn(){} の形の文を名前ごとに取り出す
+// 同じ名前が2回以上letされている場合は、書かれた順に定義されないと困るので除く
+func functionLets(stmts []ast.Statement) map[string]*ast.LetStatement {
+	lets := map[string]*ast.LetStatement{}
+	count := map[string]int{}
+
+	for _, s := range stmts {
+		let, ok := s.(*ast.LetStatement)
+		if !ok {
+			continue
+		}
+
+		count[let.Name.Value]++
+		if _, ok := let.Value.(*ast.FunctionLiteral); ok {
+			lets[let.Name.Value] = let
+		}
+	}
+
+	for name := range lets {
+		if count[name] > 1 {
+			delete(lets, name)
+		}
+	}
+
+	return lets
+}
+
+func (c *Compiler) compileFunctionLet(
+	forward *forwardFunctions,
+	let *ast.LetStatement,
+	compiled []compiledFunction,
+) (compiledFunction, error) {
+	symbol, ok := forward.defined[let.Name.Value]
+	if !ok {
+		symbol = c.symbolTable.Define(let.Name.Value)
+	}
+
+	prevPos := c.pos
+	defer func() { c.pos = prevPos }()
+
+	inFunction := forward.inFunction
+	forward.inFunction = true
+	c.pos = let.Value.Pos()
+	free, err := c.compileFunctionLiteral(let.Value.(*ast.FunctionLiteral))
+	forward.inFunction = inFunction
+	if err != nil {
+		return compiledFunction{}, err
+	}
+
+	c.pos = let.Pos()
+	c.storeSymbol(symbol)
+
+	// 先に定義したローカル関数は、この関数をまだ値が入っていない状態で捕捉しているので差し替える
+	for _, fn := range compiled {
+		for freeIndex, f := range fn.free {
+			if f != symbol {
+				continue
+			}
+
+			c.loadSymbol(fn.symbol)
+			c.loadSymbol(symbol)
+			c.emit(code.OpPatchFree, freeIndex)
+		}
+	}
+
+	return compiledFunction{symbol: symbol, free: free}, nil
+}
+
+// 名前を解決する
+// 見つからなくても関数定義の本体の中なら、同じ並びの後ろで定義される関数の名前を先に定義する
+func (c *Compiler) resolve(name string) (Symbol, bool) {
+	if symbol, ok := c.symbolTable.Resolve(name); ok {
+		return symbol, true
+	}
+
+	for i := len(c.forward) - 1; i >= 0; i-- {
+		forward := c.forward[i]
+		let, ok := forward.lets[name]
+		if !ok || !forward.inFunction {
+			continue
+		}
+
+		// 定義より前に呼ばれるかもしれないなら、値の入っていない変数を読むことになるので許さない
+		if forward.mayRunBefore(let) {
+			return Symbol{}, false
+		}
+
+		forward.defined[name] = forward.symbolTable.Define(name)
+		return c.symbolTable.Resolve(name)
+	}
+
+	return Symbol{}, false
+}
+
+// 今コンパイルしている関数定義からletまでの間に、関数を呼び出す文があるかどうか
+// 呼び出しがなければ、その間に今の関数が動くことはない
+func (f *forwardFunctions) mayRunBefore(let *ast.LetStatement) bool {
+	for _, s := range f.stmts[f.current+1:] {
+		if s == ast.Statement(let) {
+			return false
+		}
+		if containsCall(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// 関数の呼び出しを含むかどうか
+// 関数リテラルの本体は、作っただけでは実行されないので見ない
+func containsCall(node ast.Node) bool {
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		return true
+	case *ast.LetStatement:
+		return containsCall(node.Value)
+	case *ast.ReturnStatement:
+		return containsCall(node.ReturnValue)
+	case *ast.ExpressionStatement:
+		return containsCall(node.Expression)
+	case *ast.BlockStatement:
+		if node == nil {
+			return false
+		}
+		for _, s := range node.Statements {
+			if containsCall(s) {
+				return true
+			}
+		}
+	case *ast.WhileStatement:
+		return containsCall(node.Condition) || containsCall(node.Body)
+	case *ast.ForStatement:
+		return containsCall(node.Iterable) || containsCall(node.Body)
+	case *ast.PrefixExpression:
+		return containsCall(node.Right)
+	case *ast.InfixExpression:
+		return containsCall(node.Left) || containsCall(node.Right)
+	case *ast.AssignExpression:
+		return containsCall(node.Target) || containsCall(node.Value)
+	case *ast.IfExpression:
+		return containsCall(node.Condition) || containsCall(node.Consequence) || containsCall(node.Alternative)
+	case *ast.IndexExpression:
+		return containsCall(node.Left) || containsCall(node.Index)
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			if containsCall(e) {
+				return true
+			}
+		}
+	case *ast.HashLiteral:
+		for _, k := range node.Keys {
+			if containsCall(k) || containsCall(node.Pairs[k]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 中置演算子と、それに対応する命令
+// 左辺と右辺はいつも書かれた順に評価する
+var infixOperators = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"&":  code.OpBitAnd,
+	"|":  code.OpBitOr,
+	"^":  code.OpBitXor,
+	"<<": code.OpShiftLeft,
+	">>": code.OpShiftRight,
+	"<":  code.OpLessThan,
+	"<=": code.OpLessEqual,
+	">":  code.OpGreaterThan,
+	">=": code.OpGreaterEqual,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+}
+
+// && と || は左辺だけで結果が決まるなら右辺を評価しない
+// 結果は真偽値に変換せず、最後に評価した方の値になる
+//
+//	a && b:  a; OpDup; OpJumpNotTruthy end; OpPop; b; end:
+//	a || b:  a; OpDup; OpJumpTruthy end;    OpPop; b; end:
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpDup)
+
+	var jumpPos int
+	if node.Operator == "&&" {
+		jumpPos = c.emit(code.OpJumpNotTruthy, 9999)
+	} else {
+		jumpPos = c.emit(code.OpJumpTruthy, 9999)
+	}
+
+	c.emit(code.OpPop)
+
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return nil
+}
+
+// 複合代入の演算子と、それに対応する二項演算の命令
+var compoundAssignOperators = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
+// 代入式は代入した値をスタックに残す
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	op, compound := compoundAssignOperators[node.Operator]
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return c.reportAssignment(errorf(target, "undefined variable %s", target.Value), node)
+		}
+
+		// 自由変数は値のコピーしか持っていないので書き換えられない
+		if symbol.Scope != GlobalScope && symbol.Scope != LocalScope {
+			return c.reportAssignment(errorf(target, "cannot assign to %s", target.Value), node)
+		}
+
+		pending := 0
+		if compound {
+			c.loadSymbol(symbol)
+			pending = 1
+		}
+
+		err := c.compileOver(pending, node.Value)
+		if err != nil {
+			return err
+		}
+
+		if compound {
+			c.emit(op)
+		}
+
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+
+	case *ast.IndexExpression:
+		err := c.Compile(target.Left)
+		if err != nil {
+			return err
+		}
+
+		err = c.compileOver(1, target.Index)
+		if err != nil {
+			return err
+		}
+
+		// 配列と添字を1回だけ評価するために、複製してから今の値を読む
+		pending := 2
+		if compound {
+			c.emit(code.OpDup2)
+			c.emit(code.OpIndex)
+			pending = 3
+		}
+
+		err = c.compileOver(pending, node.Value)
+		if err != nil {
+			return err
+		}
+
+		if compound {
+			c.emit(op)
+		}
+
+		c.emit(code.OpSetIndex)
+
+	default:
+		return c.reportAssignment(errorf(node.Target, "invalid assignment target: %s", node.Target.String()), node)
+	}
+
+	return nil
+}
+
+// 代入先がおかしいときは右辺だけコンパイルして、その値を式の結果の代わりにする
+// 右辺の中のエラーも一緒に見つけられる
+func (c *Compiler) reportAssignment(err *Error, node *ast.AssignExpression) error {
+	if err := c.report(err); err != nil {
+		return err
+	}
+	return c.Compile(node.Value)
+}
+
+// エラー収集モードを切り替える
+func (c *Compiler) CollectErrors(on bool) {
+	c.collectErrors = on
+}
+
+// コンパイルエラーを報告する
+// 収集モードなら溜めておいてnilを返し、そのままコンパイルを続けさせる
+func (c *Compiler) report(err *Error) error {
+	if !c.collectErrors {
+		return err
+	}
+	c.errors = append(c.errors, err)
+	return nil
+}
+
+// 値を1つ積むはずだった式のエラーを報告する
+// 収集モードではnullを代わりに積んで、後ろに続く命令の形を崩さない
+func (c *Compiler) reportExpression(err *Error) error {
+	if err := c.report(err); err != nil {
+		return err
+	}
+	c.emit(code.OpNull)
+	return nil
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
 func (c *Compiler) replaceLastPopWithReturn() {
 	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
 	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
@@ -352,6 +904,56 @@ func (c *Compiler) leaveScope() code.Instructions {
 	return instructions
 }
 
+// ループの本体に入る 中でletした名前は本体を出ると見えなくなる
+func (c *Compiler) enterBlock() {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveBlock() {
+	c.symbolTable = c.symbolTable.Outer
+}
+
+func (c *Compiler) enterLoop() *LoopContext {
+	loop := &LoopContext{
+		continuePos: len(c.currentInstructions()),
+		pending:     c.scopes[c.scopeIndex].pending,
+	}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, loop)
+
+	return loop
+}
+
+func (c *Compiler) leaveLoop() {
+	loops := c.scopes[c.scopeIndex].loops
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+}
+
+// 下にn個の値を積んだままnodeをコンパイルする
+func (c *Compiler) compileOver(n int, node ast.Node) error {
+	c.scopes[c.scopeIndex].pending += n
+	defer func() { c.scopes[c.scopeIndex].pending -= n }()
+
+	return c.Compile(node)
+}
+
+// ループに入った後で積まれた値を捨てる
+// break / continue の飛び先では、スタックがループに入ったときの高さに戻っていなければならない
+func (c *Compiler) discardPending(loop *LoopContext) {
+	for i := loop.pending; i < c.scopes[c.scopeIndex].pending; i++ {
+		c.emit(code.OpPop)
+	}
+}
+
+// 関数の中からその外側のループは操作できないので、現在のスコープのループだけを見る
+func (c *Compiler) currentLoop() *LoopContext {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+
+	return loops[len(loops)-1]
+}
+
 func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
@@ -361,10 +963,48 @@ func (c *Compiler) addInstruction(ins []byte) int {
 	updateInstructions := append(c.currentInstructions(), ins...)
 
 	c.scopes[c.scopeIndex].instructions = updateInstructions
+	c.addSourceMapEntry(posNewInstruction)
 
 	return posNewInstruction
 }
 
+// 位置が前の命令と変わったときだけ記録する
+func (c *Compiler) addSourceMapEntry(offset int) {
+	sm := c.scopes[c.scopeIndex].sourceMap
+	if len(sm) > 0 && sm[len(sm)-1].Pos == c.pos {
+		return
+	}
+
+	c.scopes[c.scopeIndex].sourceMap = append(sm,
+		code.SourceMapEntry{Offset: offset, Pos: c.pos})
+}
+
+// 命令列を切り詰めたら、なくなった命令の位置も消す
+func (c *Compiler) truncateSourceMap(length int) {
+	sm := c.scopes[c.scopeIndex].sourceMap
+	for len(sm) > 0 && sm[len(sm)-1].Offset >= length {
+		sm = sm[:len(sm)-1]
+	}
+	c.scopes[c.scopeIndex].sourceMap = sm
+}
+
+// 命令に対応づけるソース上の位置
+// 二項演算や添字は、式の先頭より演算子の位置の方がエラーの場所として分かりやすい
+func sourcePos(node ast.Node) token.Pos {
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		return node.Token.Pos
+	case *ast.IndexExpression:
+		return node.Token.Pos
+	case *ast.AssignExpression:
+		return node.Token.Pos
+	case *ast.CallExpression:
+		return node.Token.Pos
+	}
+
+	return node.Pos()
+}
+
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 	previous := c.scopes[c.scopeIndex].lastInstruction
 	last := EmittedInstruction{
@@ -384,6 +1024,16 @@ func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
 }
 
+// if式のブロックの値をスタックに残す
+// 最後が式文でなければ（letやwhileなど）値がないのでnullを積む
+func (c *Compiler) keepBlockValue() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+}
+
 func (c *Compiler) removeLastPop() {
 	last := c.scopes[c.scopeIndex].lastInstruction
 	previous := c.scopes[c.scopeIndex].previousInstruction
@@ -392,6 +1042,7 @@ func (c *Compiler) removeLastPop() {
 	new := old[:last.Position]
 
 	c.scopes[c.scopeIndex].instructions = new
+	c.truncateSourceMap(len(new))
 	c.scopes[c.scopeIndex].lastInstruction = previous
 }
 
@@ -403,20 +1054,28 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 	}
 }
 
-func (c *Compiler) changeOperand(opPos int, operand int) {
+func (c *Compiler) changeOperand(opPos int, operands ...int) {
 	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+	newInstruction := code.Make(op, operands...)
 	c.replaceInstruction(opPos, newInstruction)
 }
 
+// エラーが残っているときは実行できないのでnilを返す
 func (c *Compiler) Bytecode() *Bytecode {
+	if len(c.errors) > 0 {
+		return nil
+	}
+
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	// メインプログラムの命令とソース上の位置の対応
+	SourceMap code.SourceMap
 }
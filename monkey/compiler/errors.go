@@ -0,0 +1,47 @@
+package compiler
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/token"
+	"strings"
+)
+
+// コンパイルエラー
+// エディタで飛べるように、原因になったノードの位置を持つ
+type Error struct {
+	Pos     token.Pos
+	Message string
+}
+
+func (e *Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+func errorf(node ast.Node, format string, a ...interface{}) *Error {
+	return &Error{Pos: node.Pos(), Message: fmt.Sprintf(format, a...)}
+}
+
+// エラー収集モードで見つかったコンパイルエラーの一覧
+// ソースに出てきた順に並ぶ
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// errors.As で個々の *Error を取り出せるようにする
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, e := range l {
+		errs[i] = e
+	}
+	return errs
+}
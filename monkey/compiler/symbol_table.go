@@ -1,10 +1,16 @@
 package compiler
 
+import "sort"
+
 type SymbolScope string
 
 const (
-	LocalScope  SymbolScope = "LOCAL"
-	GlobalScope SymbolScope = "GLOBAL"
+	LocalScope   SymbolScope = "LOCAL"
+	GlobalScope  SymbolScope = "GLOBAL"
+	FreeScope    SymbolScope = "FREE"
+	BuiltinScope SymbolScope = "BUILTIN"
+
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 type Symbol struct {
@@ -17,6 +23,12 @@ type SymbolTable struct {
 	Outer          *SymbolTable
 	store          map[string]Symbol
 	numDefinitions int
+	// ループの本体のように、関数の中で名前の有効範囲だけを区切る表ならtrue
+	// 変数の場所は、それを囲む関数の表から割り当てる
+	block bool
+
+	// 外側の関数のローカル変数を参照している場合、その元のシンボルを記録する
+	FreeSymbols []Symbol
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -27,36 +39,124 @@ func NewSymbolTable() *SymbolTable {
 }
 
 func (s *SymbolTable) Define(name string) Symbol {
-	symbol := Symbol{
-		Name:  name,
-		Index: s.numDefinitions,
+	symbol := s.Reserve()
+	symbol.Name = name
+
+	s.store[name] = symbol
+
+	return symbol
+}
+
+// 名前を付けずに変数の場所だけを確保する
+func (s *SymbolTable) Reserve() Symbol {
+	owner := s
+	for owner.block {
+		owner = owner.Outer
 	}
 
-	if s.Outer == nil {
+	symbol := Symbol{Index: owner.numDefinitions}
+	if owner.Outer == nil {
 		symbol.Scope = GlobalScope
 	} else {
 		symbol.Scope = LocalScope
 	}
 
-	s.store[name] = symbol
-	s.numDefinitions++
+	owner.numDefinitions++
 
 	return symbol
 }
 
+// 確保した変数の場所の数 上書きされて名前で参照できなくなったものも数える
+func (s *SymbolTable) NumDefinitions() int {
+	return s.numDefinitions
+}
+
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
 
+	// ブロックの外側は同じ関数の中なので、そのまま参照できる
+	if !ok && s.block {
+		return s.Outer.Resolve(name)
+	}
+
 	if !ok && s.Outer != nil {
-		obj, ok := s.Outer.Resolve(name)
+		obj, ok = s.Outer.Resolve(name)
+		if !ok {
+			return obj, ok
+		}
+
+		// グローバル変数と組み込み関数はどこからでも参照できるので、そのまま返却
+		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+			return obj, ok
+		}
 
-		return obj, ok
+		// 外側のローカル変数は自由変数として取り込む
+		free := s.defineFree(obj)
+		return free, true
 	}
 	return obj, ok
 }
 
+// 組み込み関数を定義する。indexはobject.Builtinsでの位置
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// 関数自身の名前を定義する。参照すると実行中のクロージャが積まれる
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{
+		Name:  original.Name,
+		Index: len(s.FreeSymbols) - 1,
+		Scope: FreeScope,
+	}
+
+	s.store[original.Name] = symbol
+	return symbol
+}
+
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	s := NewSymbolTable()
 	s.Outer = outer
 	return s
 }
+
+// 関数の中のブロック用の表を作る
+// ここで定義した名前はブロックを出ると見えなくなる
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer)
+	s.block = true
+	return s
+}
+
+// このスコープで let で定義した名前を、定義した順に返す
+func (s *SymbolTable) Definitions() []Symbol {
+	symbols := make([]Symbol, 0, s.numDefinitions)
+	for _, symbol := range s.store {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].Index < symbols[j].Index })
+	return symbols
+}
+
+// このスコープにある名前を、組み込み関数も含めて辞書順に返す
+func (s *SymbolTable) Names() []string {
+	names := make([]string, 0, len(s.store))
+	for name := range s.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
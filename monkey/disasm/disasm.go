@@ -0,0 +1,192 @@
+package disasm
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/object"
+	"sort"
+	"strconv"
+)
+
+// 命令の後ろに注釈を付けるときの、命令部分の幅
+const commentColumn = 24
+
+// バイトコード全体を人が読める形にする
+// 定数表、メインの命令列、定数表の中の関数の命令列の順に並べる
+// 出力はそのままasmパッケージでバイトコードに戻せる
+func Disassemble(bytecode *compiler.Bytecode) string {
+	var out bytes.Buffer
+
+	if len(bytecode.Constants) > 0 {
+		out.WriteString("== constants ==\n")
+		for i, c := range bytecode.Constants {
+			if _, ok := c.(*object.CompiledFunction); ok {
+				fmt.Fprintf(&out, "%4d: %s\n", i, describeConstant(c))
+			} else {
+				fmt.Fprintf(&out, "%4d: %s %s\n", i, c.Type(), describeConstant(c))
+			}
+		}
+		out.WriteString("\n")
+	}
+
+	out.WriteString("== main ==\n")
+	out.WriteString(Instructions(bytecode.Instructions, bytecode.Constants))
+
+	for i, c := range bytecode.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(&out, "\n== %s (constant %d, locals %d, params %d) ==\n",
+			functionName(fn), i, fn.NumLocals, fn.NumParameters)
+		out.WriteString(Instructions(fn.Instructions, bytecode.Constants))
+	}
+
+	return out.String()
+}
+
+// 命令列を1行1命令で書き出す
+// ジャンプ先にはラベルを付け、定数や組み込み関数を参照する命令にはその中身を注釈する
+// 壊れた命令列でも止まらずに、読めなかったバイトをそのまま書き出す
+func Instructions(ins code.Instructions, constants []object.Object) string {
+	var out bytes.Buffer
+
+	labels := scan(ins)
+
+	for i := 0; i < len(ins); {
+		if label, ok := labels[i]; ok {
+			fmt.Fprintf(&out, "%s:\n", label)
+		}
+
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			writeLine(&out, i, fmt.Sprintf(".byte %d", ins[i]), err.Error())
+			i++
+			continue
+		}
+
+		if i+def.Width() > len(ins) {
+			for j := i; j < len(ins); j++ {
+				writeLine(&out, j, fmt.Sprintf(".byte %d", ins[j]), "truncated "+def.Name)
+			}
+			break
+		}
+
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		text, comment := formatInstruction(code.Opcode(ins[i]), def, operands, labels, constants)
+		writeLine(&out, i, text, comment)
+
+		i += 1 + read
+	}
+
+	// 命令列の末尾へのジャンプ
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(&out, "%s:\n", label)
+	}
+
+	return out.String()
+}
+
+// 命令の境目と、正しい位置を指しているジャンプ先のラベルを集める
+func scan(ins code.Instructions) map[int]string {
+	boundaries := map[int]bool{len(ins): true}
+	targets := []int{}
+
+	for i := 0; i < len(ins); {
+		boundaries[i] = true
+
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			i++
+			continue
+		}
+		if i+def.Width() > len(ins) {
+			break
+		}
+
+		if code.IsJump(code.Opcode(ins[i])) {
+			targets = append(targets, int(code.ReadUint16(ins[i+1:])))
+		}
+		i += def.Width()
+	}
+
+	sort.Ints(targets)
+	labels := map[int]string{}
+	for _, t := range targets {
+		if _, ok := labels[t]; ok || !boundaries[t] {
+			continue
+		}
+		labels[t] = fmt.Sprintf("L%d", len(labels))
+	}
+
+	return labels
+}
+
+func formatInstruction(
+	op code.Opcode,
+	def *code.Definition,
+	operands []int,
+	labels map[int]string,
+	constants []object.Object,
+) (string, string) {
+	var text bytes.Buffer
+	var comment string
+
+	text.WriteString(def.Name)
+	for i, o := range operands {
+		if i == 0 && code.IsJump(op) {
+			if label, ok := labels[o]; ok {
+				text.WriteString(" " + label)
+				continue
+			}
+			comment = fmt.Sprintf("invalid jump target %04d", o)
+		}
+		fmt.Fprintf(&text, " %d", o)
+	}
+
+	switch op {
+	case code.OpConstant, code.OpClosure:
+		if operands[0] < len(constants) {
+			comment = describeConstant(constants[operands[0]])
+		} else if constants != nil {
+			comment = fmt.Sprintf("constant %d out of range", operands[0])
+		}
+	case code.OpGetBuiltin:
+		if operands[0] < len(object.Builtins) {
+			comment = object.Builtins[operands[0]].Name
+		} else {
+			comment = fmt.Sprintf("builtin %d out of range", operands[0])
+		}
+	}
+
+	return text.String(), comment
+}
+
+func writeLine(out *bytes.Buffer, offset int, text, comment string) {
+	if comment == "" {
+		fmt.Fprintf(out, "%04d %s\n", offset, text)
+		return
+	}
+	fmt.Fprintf(out, "%04d %-*s ; %s\n", offset, commentColumn, text, comment)
+}
+
+func describeConstant(obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.String:
+		return strconv.Quote(obj.Value)
+	case *object.CompiledFunction:
+		return functionName(obj)
+	default:
+		return obj.Inspect()
+	}
+}
+
+func functionName(fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return "fn <anonymous>"
+	}
+	return "fn " + fn.Name
+}
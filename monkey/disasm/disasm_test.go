@@ -0,0 +1,149 @@
+package disasm
+
+import (
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"testing"
+)
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func TestDisassemble(t *testing.T) {
+	input := `let add = fn(a, b) { a + b };
+if (add(1, 2) > 2) { "big" } else { len([]) };`
+
+	expected := `== constants ==
+   0: fn add
+   1: INTEGER 1
+   2: INTEGER 2
+   3: INTEGER 2
+   4: STRING "big"
+
+== main ==
+0000 OpClosure 0 0            ; fn add
+0004 OpSetGlobal 0
+0007 OpGetGlobal 0
+0010 OpConstant 1             ; 1
+0013 OpConstant 2             ; 2
+0016 OpCall 2
+0018 OpConstant 3             ; 2
+0021 OpGreaterThan
+0022 OpJumpNotTruthy L0
+0025 OpConstant 4             ; "big"
+0028 OpJump L1
+L0:
+0031 OpGetBuiltin 0           ; len
+0033 OpArray 0
+0036 OpCall 1
+L1:
+0038 OpPop
+
+== fn add (constant 0, locals 2, params 2) ==
+0000 OpGetLocal 0
+0002 OpGetLocal 1
+0004 OpAdd
+0005 OpReturnValue
+`
+
+	got := Disassemble(compile(t, input))
+	if got != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func TestDisassembleLoopLabels(t *testing.T) {
+	input := `let xs = [];
+for (x in xs) { if (x) { break; } }`
+
+	expected := `0000 OpArray 0
+0003 OpSetGlobal 0
+0006 OpGetGlobal 0
+0009 OpIterInit
+0010 OpSetGlobal 1
+L0:
+0013 OpGetGlobal 1
+0016 OpIterNext L3 1
+0020 OpSetGlobal 2
+0023 OpGetGlobal 2
+0026 OpJumpNotTruthy L1
+0029 OpJump L3
+0032 OpNull
+0033 OpJump L2
+L1:
+0036 OpNull
+L2:
+0037 OpPop
+0038 OpJump L0
+L3:
+`
+
+	got := Instructions(compile(t, input).Instructions, nil)
+	if got != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func TestDisassembleMalformed(t *testing.T) {
+	tests := []struct {
+		name      string
+		ins       code.Instructions
+		constants []object.Object
+		expected  string
+	}{
+		{
+			"unknown opcode",
+			code.Instructions{255, byte(code.OpPop)},
+			nil,
+			"0000 .byte 255                ; opcode 255 undefined\n0001 OpPop\n",
+		},
+		{
+			"truncated operands",
+			append(code.Make(code.OpTrue), byte(code.OpConstant), 0),
+			nil,
+			"0000 OpTrue\n0001 .byte 0                  ; truncated OpConstant\n0002 .byte 0                  ; truncated OpConstant\n",
+		},
+		{
+			"jump into the middle of an instruction",
+			append(code.Make(code.OpJump, 4), code.Make(code.OpConstant, 0)...),
+			[]object.Object{&object.Integer{Value: 7}},
+			"0000 OpJump 4                 ; invalid jump target 0004\n0003 OpConstant 0             ; 7\n",
+		},
+		{
+			"constant out of range",
+			code.Make(code.OpConstant, 3),
+			[]object.Object{&object.Integer{Value: 7}},
+			"0000 OpConstant 3             ; constant 3 out of range\n",
+		},
+		{
+			"builtin out of range",
+			code.Make(code.OpGetBuiltin, 200),
+			nil,
+			"0000 OpGetBuiltin 200         ; builtin 200 out of range\n",
+		},
+	}
+
+	for _, tt := range tests {
+		got := Instructions(tt.ins, tt.constants)
+		if got != tt.expected {
+			t.Errorf("%s: wrong disassembly.\nwant=%q\ngot =%q", tt.name, tt.expected, got)
+		}
+	}
+}
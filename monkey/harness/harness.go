@@ -0,0 +1,120 @@
+package harness
+
+import (
+	"errors"
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"strings"
+)
+
+// 同じプログラムを評価器とコンパイラ + VMの両方で実行し、結果を比較するための道具
+// 結果は最後に評価された式のInspect()、エラーで止まった場合は "aborted: <メッセージ>" で表す
+// エラーの値で終わった場合 ("ERROR: <メッセージ>") と、止まった場合とを区別して比べる
+
+type Mismatch struct {
+	Evaluator string
+	VM        string
+}
+
+func (m *Mismatch) Error() string {
+	return fmt.Sprintf("evaluator and vm disagree.\neval=%q\nvm=%q", m.Evaluator, m.VM)
+}
+
+func parse(input string) (*ast.Program, error) {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parse errors:\n\t%s", strings.Join(p.Errors(), "\n\t"))
+	}
+
+	return program, nil
+}
+
+// 評価器で実行した結果
+func RunEvaluator(input string) (string, error) {
+	program, err := parse(input)
+	if err != nil {
+		return "", err
+	}
+
+	env := object.NewEnviroment()
+	result := evaluator.Eval(program, env)
+	if result == nil {
+		return "", nil
+	}
+
+	// 評価器のエラーは必ず評価を打ち切る
+	if errObj, ok := result.(*object.Error); ok {
+		return aborted(errObj.Message), nil
+	}
+
+	return result.Inspect(), nil
+}
+
+// コンパイラ + VMで実行した結果
+func RunVM(input string) (string, error) {
+	program, err := parse(input)
+	if err != nil {
+		return "", err
+	}
+
+	comp := compiler.New()
+	err = comp.Compile(program)
+	if err != nil {
+		// 評価器のエラーには位置がないので、メッセージだけを比べる
+		var compileErr *compiler.Error
+		if errors.As(err, &compileErr) {
+			return aborted(compileErr.Message), nil
+		}
+		return aborted(err.Error()), nil
+	}
+
+	machine := vm.New(comp.Bytecode())
+	err = machine.Run()
+	if err != nil {
+		// 評価器のエラーには命令の位置がないので、メッセージだけを比べる
+		var vmErr *vm.RuntimeError
+		if errors.As(err, &vmErr) {
+			err = vmErr.Err
+		}
+		return aborted(err.Error()), nil
+	}
+
+	result := machine.LastPoppedStackElem()
+	if result == nil {
+		return "", nil
+	}
+
+	return result.Inspect(), nil
+}
+
+func aborted(message string) string {
+	return "aborted: " + message
+}
+
+// 両方で実行し、結果が一致すればその値を、食い違えば*Mismatchを返却する
+func Compare(input string) (string, error) {
+	evaluated, err := RunEvaluator(input)
+	if err != nil {
+		return "", err
+	}
+
+	executed, err := RunVM(input)
+	if err != nil {
+		return "", err
+	}
+
+	if evaluated != executed {
+		return "", &Mismatch{Evaluator: evaluated, VM: executed}
+	}
+
+	return evaluated, nil
+}
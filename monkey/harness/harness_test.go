@@ -0,0 +1,95 @@
+package harness
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdata/*.mk を評価器とVMの両方で実行し、互いの結果と同名の .out ファイルの内容を比較する
+func TestCorpus(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.mk"))
+	if err != nil {
+		t.Fatalf("could not list testdata: %s", err)
+	}
+
+	if len(files) == 0 {
+		t.Fatalf("no programs found in testdata")
+	}
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".mk")
+
+		t.Run(name, func(t *testing.T) {
+			input, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("could not read %s: %s", file, err)
+			}
+
+			expected, err := os.ReadFile(strings.TrimSuffix(file, ".mk") + ".out")
+			if err != nil {
+				t.Fatalf("could not read expected output for %s: %s", file, err)
+			}
+
+			evaluated, err := RunEvaluator(string(input))
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+
+			executed, err := RunVM(string(input))
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+
+			want := strings.TrimSpace(string(expected))
+
+			if evaluated != executed {
+				t.Errorf("evaluator and vm disagree.\neval=%q\nvm=%q", evaluated, executed)
+			}
+
+			if evaluated != want {
+				t.Errorf("evaluator output wrong.\nwant=%q\ngot=%q", want, evaluated)
+			}
+
+			if executed != want {
+				t.Errorf("vm output wrong.\nwant=%q\ngot=%q", want, executed)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	result, err := Compare("let add = fn(a, b) { a + b }; add(1, 2)")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if result != "3" {
+		t.Errorf("wrong result. want=%q, got=%q", "3", result)
+	}
+
+	_, err = Compare("let x = ")
+	if err == nil {
+		t.Fatalf("expected parse error but got none")
+	}
+}
+
+func TestAbortedRuns(t *testing.T) {
+	// エラーで止まったことが結果に残り、その後ろの値にはならない
+	expected := "aborted: argument to `len` not supported, got INTEGER"
+
+	for name, run := range map[string]func(string) (string, error){
+		"evaluator": RunEvaluator,
+		"vm":        RunVM,
+	} {
+		result, err := run("len(1); 5")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+
+		if result != expected {
+			t.Errorf("%s: wrong result. want=%q, got=%q", name, expected, result)
+		}
+	}
+}
@@ -0,0 +1,2 @@
+let a = 5 + 10 * 2 + 15 / 3;
+(a - 5) * 2 + -10
@@ -0,0 +1,11 @@
+let map = fn(arr, f) {
+	let iter = fn(arr, acc) {
+		if (len(arr) == 0) {
+			acc
+		} else {
+			iter(rest(arr), push(acc, f(first(arr))));
+		}
+	};
+	iter(arr, []);
+};
+map([1, 2, 3], fn(x) { x + 1 })
@@ -0,0 +1,7 @@
+let f = fn() {
+	let x = 1;
+	let g = fn() { x = 2 };
+	g();
+	x
+};
+f()
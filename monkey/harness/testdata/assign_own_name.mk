@@ -0,0 +1,7 @@
+let count = 0;
+let f = fn() {
+	count += 1;
+	f = 5;
+};
+f();
+[count, f]
@@ -0,0 +1,15 @@
+let counts = {};
+let words = ["a", "b", "a", "c", "a"];
+for (w in words) {
+	if (!counts[w]) {
+		counts[w] = 0;
+	}
+	counts[w] += 1;
+}
+let squares = [0, 0, 0, 0];
+let i = 0;
+while (i < 4) {
+	squares[i] = i * i;
+	i += 1;
+}
+[counts, squares, i]
@@ -0,0 +1,2 @@
+let t = true;
+-t
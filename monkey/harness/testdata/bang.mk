@@ -0,0 +1 @@
+[!true, !false, !(if (false) { 1 }), !5, !!5]
@@ -0,0 +1,4 @@
+let f = fn() { g() };
+let early = f();
+let g = fn() { [1, 2, 3] };
+early
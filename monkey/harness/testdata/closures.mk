@@ -0,0 +1,3 @@
+let adder = fn(x) { fn(y) { x + y } };
+let addTen = adder(10);
+adder(1)(2) + addTen(20)
@@ -0,0 +1,5 @@
+let a = 1 < 2;
+let b = 3 > 2;
+let c = 1 == 1;
+let d = true != false;
+if (a) { if (b) { if (c) { d } } }
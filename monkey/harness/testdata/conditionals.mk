@@ -0,0 +1,3 @@
+let pick = fn(c) { if (c) { 10 } else { 20 } };
+let maybe = fn(c) { if (c) { 10 } };
+[pick(1 < 2), pick(1 > 2), maybe(false)]
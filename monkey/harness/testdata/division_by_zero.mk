@@ -0,0 +1,2 @@
+let avg = fn(arr) { let total = 0; for (x in arr) { total += x; } total / len(arr) };
+[avg([2, 4, 6]), avg([])]
@@ -0,0 +1,3 @@
+let earlyExit = fn() { return 99; 100; };
+let nested = fn() { if (true) { if (true) { return earlyExit(); } } return 1; };
+nested()
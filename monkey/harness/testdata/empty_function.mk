@@ -0,0 +1,2 @@
+let noReturn = fn() {};
+noReturn()
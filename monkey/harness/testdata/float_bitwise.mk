@@ -0,0 +1,2 @@
+let mask = fn(x) { x & 3 };
+[mask(6), mask(6.5)]
@@ -0,0 +1,2 @@
+let label = fn(x) { x + " items" };
+label(2.5)
@@ -0,0 +1,3 @@
+let celsius = fn(f) { (f - 32) * 5 / 9.0 };
+let percent = fn(part, total) { part * 100.0 / total };
+[celsius(212), celsius(-40), percent(3, 8), 1e-3 + .5, 2.0 * 3, 1 < 1.5, 2 == 2.0]
@@ -0,0 +1,13 @@
+let words = {"one": 1, "two": 2, "three": 3};
+let total = 0;
+let names = "";
+for (name, n in words) {
+	total += n;
+	names = names + name + " ";
+}
+let chars = [];
+for (c in "hey") {
+	if (c == "e") { continue; }
+	chars = push(chars, c);
+}
+[total, names, chars, words]
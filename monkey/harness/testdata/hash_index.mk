@@ -0,0 +1,2 @@
+let h = {"one": 5, 2: 10, true: 7};
+[h["one"], h["two"], h[1 + 1], h[1 < 2]]
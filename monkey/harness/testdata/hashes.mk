@@ -0,0 +1,3 @@
+let people = [{"name": "Alice", "age": 24}, {"name": "Monkey", "age": 3}];
+let monkey = people[1];
+monkey["name"] + " " + "3" + " " + "null"
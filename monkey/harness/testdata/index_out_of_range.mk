@@ -0,0 +1,2 @@
+let arr = [1, 2, 3];
+[arr[3], arr[-1], arr[len(arr) - 1]]
@@ -0,0 +1,7 @@
+let run = fn() {
+	let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+	let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+	let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } };
+	[isEven(10), isOdd(10), fact(5)]
+};
+run()
@@ -0,0 +1,12 @@
+let calls = 0;
+let check = fn(x) { calls += 1; x };
+let lookup = fn(h, k) { h[k] || "none" };
+let results = [
+	check(false) && check(true),
+	check(true) || check(false),
+	check(1) && check(2),
+	lookup({"a": "found"}, "a"),
+	lookup({}, "b"),
+	1 < 2 && 3 > 2 || false
+];
+[results, calls]
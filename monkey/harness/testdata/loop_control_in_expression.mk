@@ -0,0 +1,20 @@
+let i = 0;
+while (i < 5000) {
+	i += 1;
+	let y = 1 + if (true) { continue; } else { 2 };
+}
+
+let xs = [];
+for (x in [1, 2, 3, 4, 5]) {
+	xs = push(xs, [x, if (x % 2 == 0) { continue; } else { x * 10 }]);
+	let h = {"k": x, "v": [x, if (x > 3) { break; } else { x }]};
+}
+
+let f = fn() {
+	let n = 0;
+	while (true) {
+		n += [n, {"a": 1 + if (n >= 3) { return n * 100; } else { 1 }}][1]["a"];
+	}
+};
+
+[i, len(xs), xs[2], f()]
@@ -0,0 +1,21 @@
+let x = "outer";
+let n = 0;
+let seen = [];
+for (x in [1, 2, 3]) {
+	let n = n + x;
+	seen = push(seen, n);
+}
+
+let makeAll = fn() {
+	let i = 0;
+	let fs = [];
+	while (i < 3) {
+		let captured = i * 10;
+		fs = push(fs, fn() { captured });
+		i += 1;
+	}
+	fs
+};
+let fs = makeAll();
+
+[x, n, seen, fs[0](), fs[2]()]
@@ -0,0 +1,12 @@
+let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+let limit = 9;
+let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+
+let run = fn() {
+	let ping = fn(n) { if (n == 0) { "ping" } else { pong(n - 1) } };
+	let names = ["ping", "pong"];
+	let pong = fn(n) { if (n == 0) { "pong" } else { ping(n - 1) } };
+	[ping(limit), pong(limit), names]
+};
+
+[isEven(limit), isOdd(limit), run()]
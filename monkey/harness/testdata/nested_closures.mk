@@ -0,0 +1,7 @@
+let a = 1;
+let newAdderOuter = fn(b) {
+	fn(c) {
+		fn(d) { a + b + c + d };
+	};
+};
+newAdderOuter(2)(3)(8)
@@ -0,0 +1,10 @@
+let isEven = fn(n) { n % 2 == 0 };
+let popcount = fn(n) {
+	let count = 0;
+	while (n > 0) {
+		count += n & 1;
+		n = n >> 1;
+	}
+	count
+};
+[isEven(10), isEven(7), popcount(255), 1 << 10, 5 ^ 3, 5 | 2, 3 <= 3, 4 >= 5, 17 % 5]
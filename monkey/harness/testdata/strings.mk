@@ -0,0 +1,3 @@
+let greet = fn(name) { "Hello, " + name + "!" };
+let s = greet("Monkey");
+s + " " + "14"
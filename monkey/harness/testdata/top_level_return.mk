@@ -0,0 +1,5 @@
+let xs = [1, 2, 3];
+for (x in xs) {
+	if (x == 2) { return [x, "early"]; }
+}
+"unreachable"
@@ -0,0 +1,2 @@
+let f = fn(x) { x + true };
+f(5)
@@ -0,0 +1 @@
+"Hello" - "World"
@@ -0,0 +1,12 @@
+let find = fn(arr, target) {
+	let search = fn(arr, i) {
+		while (true) {
+			if (len(arr) == 0) { break; }
+			if (first(arr) == target) { return i; }
+			return search(rest(arr), i + 1);
+		}
+		-1
+	};
+	search(arr, 0);
+};
+[find([5, 6, 7], 7), find([5, 6, 7], 9), if (true) { let x = 1; }]
@@ -0,0 +1,2 @@
+let add = fn(a, b) { a + b };
+add(1)
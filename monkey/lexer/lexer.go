@@ -14,10 +14,21 @@ type Lexer struct {
 	readPosition int
 	// 現在の調査文字
 	ch byte
+
+	// 位置情報用 ファイル名、現在の行、その行の先頭のバイト位置
+	file      string
+	line      int
+	lineStart int
 }
 
 // ポインタ使ってるから値は上書き
 func (l *Lexer) readChar() {
+	// 改行を読み終えたら次の行へ
+	if l.ch == '\n' {
+		l.line++
+		l.lineStart = l.readPosition
+	}
+
 	// 文字数の数 ＝　バイト数としているlenなのでASCLLのみに対応
 	if l.readPosition >= len(l.input) {
 		// 終わりに達したら
@@ -34,8 +45,13 @@ func (l *Lexer) readChar() {
 
 // Lexter構造体を新しく作成
 func New(input string) *Lexer {
+	return NewWithFile("", input)
+}
+
+// エラーの位置にファイル名を含めたいときに使う
+func NewWithFile(file string, input string) *Lexer {
 	// Lexter構造体に分析する文字列を格納し、そのアドレスをlに入れる。
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, file: file, line: 1}
 	// 最初の文字を読み込む
 	l.readChar()
 	return l
@@ -50,6 +66,10 @@ func (l *Lexer) NextToken() token.Token {
 	// スペースの場合は、読み飛ばす
 	l.skipWhitespace()
 
+	// トークンの先頭の位置
+	pos := l.currentPos()
+	tok.Pos = pos
+
 	// 読み取った文字をswitchにかける
 	switch l.ch {
 	case '=':
@@ -74,9 +94,17 @@ func (l *Lexer) NextToken() token.Token {
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.PLUS_ASSIGN)
+		} else {
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.MINUS_ASSIGN)
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '!':
 		// != ２文字タイプ判定
 		if l.peekChar() == '=' {
@@ -88,13 +116,51 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.SLASH_ASSIGN)
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.ASTERISK_ASSIGN)
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.readTwoCharToken(token.LT_EQ)
+		case '<':
+			tok = l.readTwoCharToken(token.SHIFT_LEFT)
+		default:
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.readTwoCharToken(token.GT_EQ)
+		case '>':
+			tok = l.readTwoCharToken(token.SHIFT_RIGHT)
+		default:
+			tok = newToken(token.GT, l.ch)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
+	case '^':
+		tok = newToken(token.BIT_XOR, l.ch)
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.readTwoCharToken(token.AND)
+		} else {
+			tok = newToken(token.BIT_AND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = l.readTwoCharToken(token.OR)
+		} else {
+			tok = newToken(token.BIT_OR, l.ch)
+		}
 	case '{':
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
@@ -123,12 +189,11 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.LookupIdent(tok.Literal)
 			// リテラルを返却
 			return tok
-		} else if isDigit(l.ch) {
-			// 数値であらわすことを定義するINTをTypeに代入
-			tok.Type = token.INT
-			// ここでは　'542'とか '0'が帰ってくる
+		} else if isDigit(l.ch) || l.ch == '.' && isDigit(l.peekChar()) {
+			// ここでは　'542'とか '0'とか'1.5'が帰ってくる
 			// だいじなのは数値でなく数字が帰ってくることである
-			tok.Literal = l.readNumber()
+			// 小数点か指数があればFLOAT、なければINT
+			tok.Literal, tok.Type = l.readNumber()
 
 			return tok
 		} else {
@@ -136,11 +201,22 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.ILLEGAL, l.ch)
 		}
 	}
+	tok.Pos = pos
 	// 次の文字へ
 	l.readChar()
 	return tok
 }
 
+// 今読んでいる文字の位置
+func (l *Lexer) currentPos() token.Pos {
+	return token.Pos{
+		File:   l.file,
+		Line:   l.line,
+		Column: l.position - l.lineStart + 1,
+		Offset: l.position,
+	}
+}
+
 // 空白や改行をスキップさせる
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
@@ -168,15 +244,41 @@ func isLetter(ch byte) bool {
 }
 
 // 数字の読み取り
-func (l *Lexer) readNumber() string {
+// 1.5 や .5 や 1e-3 のような小数も読む
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
-	for isDigit(l.ch) {
+	tokenType := token.TokenType(token.INT)
+
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
 		l.readChar()
+		l.readDigits()
+	}
+
+	// 指数部 e の後に数字が続かなければ、eは次のトークンとして残す
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if isDigit(next) || (next == '+' || next == '-') && isDigit(l.peekCharAt(2)) {
+			tokenType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
 	}
 
 	// 数値を返却
 	// ここで返却されるのは数字であって数値ではない
-	return l.input[position:l.position]
+	return l.input[position:l.position], tokenType
+}
+
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) {
+		l.readChar()
+	}
 }
 
 // 数字かどうか
@@ -190,6 +292,14 @@ func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
+// += のような2文字の演算子を読み取る
+// 読み終わったとき、l.chは2文字目を指している
+func (l *Lexer) readTwoCharToken(tokenType token.TokenType) token.Token {
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: string(ch) + string(l.ch)}
+}
+
 // 一つ先を読み取る
 // peek(のぞき見)
 func (l *Lexer) peekChar() byte {
@@ -202,6 +312,15 @@ func (l *Lexer) peekChar() byte {
 	}
 }
 
+// n文字先を読み取る peekCharAt(1)はpeekChar()と同じ
+func (l *Lexer) peekCharAt(n int) byte {
+	pos := l.position + n
+	if pos >= len(l.input) {
+		return 0
+	}
+	return l.input[pos]
+}
+
 func (l *Lexer) readString() string {
 	// " "で区切られた箇所をトークン化
 	position := l.position + 1
@@ -27,6 +27,11 @@ func TestNextToken(t *testing.T) {
 	"foo bar"
 	[1, 2];
 	{"foo": "bar"}
+	while (true) { break; continue; }
+	x += 1; x -= 1; x *= 2; x /= 2;
+	1.5 .5 1e-3 2E+10 3e x.y
+	a && b || c
+	<= >= % & | ^ << >>
 	`
 
 	// 構造体
@@ -123,6 +128,54 @@ func TestNextToken(t *testing.T) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		{token.WHILE, "while"},
+		{token.LPAREN, "("},
+		{token.TRUE, "true"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.BREAK, "break"},
+		{token.SEMICOLON, ";"},
+		{token.CONTINUE, "continue"},
+		{token.SEMICOLON, ";"},
+		{token.RBRACE, "}"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.FLOAT, "1.5"},
+		{token.FLOAT, ".5"},
+		{token.FLOAT, "1e-3"},
+		{token.FLOAT, "2E+10"},
+		{token.INT, "3"},
+		{token.IDENT, "e"},
+		{token.IDENT, "x"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "y"},
+		{token.IDENT, "a"},
+		{token.AND, "&&"},
+		{token.IDENT, "b"},
+		{token.OR, "||"},
+		{token.IDENT, "c"},
+		{token.LT_EQ, "<="},
+		{token.GT_EQ, ">="},
+		{token.PERCENT, "%"},
+		{token.BIT_AND, "&"},
+		{token.BIT_OR, "|"},
+		{token.BIT_XOR, "^"},
+		{token.SHIFT_LEFT, "<<"},
+		{token.SHIFT_RIGHT, ">>"},
 		{token.EOF, ""},
 	}
 
@@ -144,3 +197,40 @@ func TestNextToken(t *testing.T) {
 	}
 
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 10;\n  x >= 2.5;\n\"s\""
+
+	tests := []struct {
+		expectedLiteral string
+		expectedPos     token.Pos
+	}{
+		{"let", token.Pos{File: "a.mk", Line: 1, Column: 1, Offset: 0}},
+		{"x", token.Pos{File: "a.mk", Line: 1, Column: 5, Offset: 4}},
+		{"=", token.Pos{File: "a.mk", Line: 1, Column: 7, Offset: 6}},
+		{"10", token.Pos{File: "a.mk", Line: 1, Column: 9, Offset: 8}},
+		{";", token.Pos{File: "a.mk", Line: 1, Column: 11, Offset: 10}},
+		{"x", token.Pos{File: "a.mk", Line: 2, Column: 3, Offset: 14}},
+		{">=", token.Pos{File: "a.mk", Line: 2, Column: 5, Offset: 16}},
+		{"2.5", token.Pos{File: "a.mk", Line: 2, Column: 8, Offset: 19}},
+		{";", token.Pos{File: "a.mk", Line: 2, Column: 11, Offset: 22}},
+		{"s", token.Pos{File: "a.mk", Line: 3, Column: 1, Offset: 24}},
+		{"", token.Pos{File: "a.mk", Line: 3, Column: 4, Offset: 27}},
+	}
+
+	l := NewWithFile("a.mk", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Errorf("tests[%d] - pos wrong. expected=%+v, got=%+v",
+				i, tt.expectedPos, tok.Pos)
+		}
+	}
+}
@@ -0,0 +1,465 @@
+// 端末で1行ずつ編集しながら入力を読む
+//
+// 矢印キーでのカーソル移動と履歴の呼び出し、Ctrl-R での履歴の検索、Tab での補完ができる
+// 端末を生モードにするのは ReadLine の間だけで、実行結果を出力している間は元に戻しておく
+// 表示は1行に収まる前提で、端末の幅を超えた行の折り返しは考えない
+package lineedit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Ctrl-C で入力を取り消したとき ReadLine が返す
+var ErrInterrupted = errors.New("interrupted")
+
+// 覚えておく履歴の最大数
+const HistoryLimit = 1000
+
+// カーソルより前の文字列を受け取り、補完する単語の開始位置 (バイト単位) と候補を返す
+// 候補は単語全体を置き換える文字列
+type CompleteFunc func(head string) (start int, candidates []string)
+
+type Editor struct {
+	Complete CompleteFunc
+
+	in      *bufio.Reader
+	out     io.Writer
+	history []string
+
+	// 端末を生モードにして、元に戻す関数を返す 端末でなければ nil
+	raw func() (func(), error)
+	// 読んだが使わなかったキー
+	unread *key
+}
+
+// 端末を使わない Editor を作る 入力はそのまま解釈する
+func New(in io.Reader, out io.Writer) *Editor {
+	return &Editor{in: bufio.NewReader(in), out: out}
+}
+
+// in と out が両方とも端末なら、生モードで読む Editor を作る
+func NewTerminal(in, out *os.File) (*Editor, bool) {
+	if !isTerminal(int(in.Fd())) || !isTerminal(int(out.Fd())) {
+		return nil, false
+	}
+
+	e := New(in, out)
+	e.raw = func() (func(), error) { return makeRaw(int(in.Fd())) }
+	return e, true
+}
+
+// 特別なキーは Unicode の範囲外の値で表す
+type key rune
+
+const (
+	keyUnknown key = -(iota + 1)
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+)
+
+const (
+	ctrlA     key = 1
+	ctrlB     key = 2
+	ctrlC     key = 3
+	ctrlD     key = 4
+	ctrlE     key = 5
+	ctrlF     key = 6
+	ctrlG     key = 7
+	ctrlH     key = 8
+	tab       key = 9
+	ctrlJ     key = 10
+	ctrlK     key = 11
+	ctrlL     key = 12
+	enter     key = 13
+	ctrlN     key = 14
+	ctrlP     key = 16
+	ctrlR     key = 18
+	ctrlU     key = 21
+	ctrlW     key = 23
+	escape    key = 27
+	backspace key = 127
+)
+
+// 編集中の行
+type line struct {
+	prompt string
+	buf    []rune
+	pos    int
+	// 履歴のどこを表示しているか len(history) なら編集中の行
+	historyIndex int
+	// 履歴を遡る前に編集していた行
+	saved []rune
+}
+
+// プロンプトを表示して1行読む
+// 空の行で Ctrl-D を押すか入力が終われば io.EOF、Ctrl-C なら ErrInterrupted を返す
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+
+	l := &line{prompt: prompt, historyIndex: len(e.history)}
+	e.refresh(l)
+
+	for {
+		k, err := e.readKey()
+		if err != nil {
+			if err == io.EOF && len(l.buf) > 0 {
+				io.WriteString(e.out, "\r\n")
+				return string(l.buf), nil
+			}
+			return "", err
+		}
+
+		switch k {
+		case enter, ctrlJ:
+			io.WriteString(e.out, "\r\n")
+			return string(l.buf), nil
+		case ctrlC:
+			io.WriteString(e.out, "^C\r\n")
+			return "", ErrInterrupted
+		case ctrlD:
+			if len(l.buf) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+			l.delete()
+		case keyDelete:
+			l.delete()
+		case backspace, ctrlH:
+			if l.pos > 0 {
+				l.pos--
+				l.delete()
+			}
+		case keyLeft, ctrlB:
+			if l.pos > 0 {
+				l.pos--
+			}
+		case keyRight, ctrlF:
+			if l.pos < len(l.buf) {
+				l.pos++
+			}
+		case keyHome, ctrlA:
+			l.pos = 0
+		case keyEnd, ctrlE:
+			l.pos = len(l.buf)
+		case keyUp, ctrlP:
+			e.historyPrev(l)
+		case keyDown, ctrlN:
+			e.historyNext(l)
+		case ctrlK:
+			l.buf = l.buf[:l.pos]
+		case ctrlU:
+			l.buf = l.buf[l.pos:]
+			l.pos = 0
+		case ctrlW:
+			l.deleteWord()
+		case ctrlL:
+			io.WriteString(e.out, "\x1b[H\x1b[2J")
+		case tab:
+			e.complete(l)
+		case ctrlR:
+			if done, err := e.search(l); done || err != nil {
+				return string(l.buf), err
+			}
+		default:
+			if k >= 0 && unicode.IsPrint(rune(k)) {
+				l.insert([]rune{rune(k)})
+			}
+		}
+
+		e.refresh(l)
+	}
+}
+
+// 行を書き直して、カーソルを編集位置に置く
+func (e *Editor) refresh(l *line) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", l.prompt, string(l.buf))
+	if n := len(l.buf) - l.pos; n > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", n)
+	}
+}
+
+func (l *line) insert(r []rune) {
+	buf := make([]rune, 0, len(l.buf)+len(r))
+	buf = append(buf, l.buf[:l.pos]...)
+	buf = append(buf, r...)
+	l.buf = append(buf, l.buf[l.pos:]...)
+	l.pos += len(r)
+}
+
+// カーソル位置の1文字を消す
+func (l *line) delete() {
+	if l.pos < len(l.buf) {
+		l.buf = append(l.buf[:l.pos], l.buf[l.pos+1:]...)
+	}
+}
+
+// カーソルの前の単語を、その前の空白ごと消す
+func (l *line) deleteWord() {
+	start := l.pos
+	for start > 0 && l.buf[start-1] == ' ' {
+		start--
+	}
+	for start > 0 && l.buf[start-1] != ' ' {
+		start--
+	}
+	l.buf = append(l.buf[:start], l.buf[l.pos:]...)
+	l.pos = start
+}
+
+func (l *line) set(s []rune) {
+	l.buf = append([]rune{}, s...)
+	l.pos = len(l.buf)
+}
+
+func (e *Editor) historyPrev(l *line) {
+	if l.historyIndex == 0 {
+		return
+	}
+	if l.historyIndex == len(e.history) {
+		l.saved = l.buf
+	}
+	l.historyIndex--
+	l.set([]rune(e.history[l.historyIndex]))
+}
+
+func (e *Editor) historyNext(l *line) {
+	if l.historyIndex == len(e.history) {
+		return
+	}
+	l.historyIndex++
+	if l.historyIndex == len(e.history) {
+		l.set(l.saved)
+		return
+	}
+	l.set([]rune(e.history[l.historyIndex]))
+}
+
+// 候補が1つならそれに、複数なら共通する部分まで置き換える
+// それ以上伸ばせないときは候補を一覧にする
+func (e *Editor) complete(l *line) {
+	if e.Complete == nil {
+		return
+	}
+
+	head := string(l.buf[:l.pos])
+	start, candidates := e.Complete(head)
+	if len(candidates) == 0 || start < 0 || start > len(head) {
+		io.WriteString(e.out, "\a")
+		return
+	}
+
+	word := head[start:]
+	replacement := candidates[0]
+	if len(candidates) > 1 {
+		replacement = commonPrefix(candidates)
+		if len(replacement) <= len(word) {
+			fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+			return
+		}
+	}
+
+	newHead := []rune(head[:start] + replacement)
+	l.buf = append(newHead, l.buf[l.pos:]...)
+	l.pos = len(newHead)
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
+
+// Ctrl-R で履歴を新しい方から検索する
+// Enter ならその行で入力を終え (true を返す)、Ctrl-G か Ctrl-C で元の行に戻る
+// それ以外のキーは見つかった行を編集中の行にしてから、普通に処理する
+func (e *Editor) search(l *line) (bool, error) {
+	var query []rune
+	index := len(e.history)
+	match := ""
+
+	// from から古い方へ query を含む行を探す
+	find := func(from int) {
+		if from >= len(e.history) {
+			from = len(e.history) - 1
+		}
+		for i := from; i >= 0; i-- {
+			if strings.Contains(e.history[i], string(query)) {
+				index = i
+				match = e.history[i]
+				return
+			}
+		}
+	}
+
+	for {
+		fmt.Fprintf(e.out, "\r(reverse-i-search)`%s': %s\x1b[K", string(query), match)
+
+		k, err := e.readKey()
+		if err != nil {
+			return false, err
+		}
+
+		switch k {
+		case ctrlR:
+			find(index - 1)
+		case backspace, ctrlH:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				index, match = len(e.history), ""
+				if len(query) > 0 {
+					find(len(e.history) - 1)
+				}
+			}
+		case ctrlG, ctrlC:
+			return false, nil
+		case enter, ctrlJ:
+			l.set([]rune(match))
+			io.WriteString(e.out, "\r\n")
+			return true, nil
+		default:
+			if k >= 0 && unicode.IsPrint(rune(k)) {
+				query = append(query, rune(k))
+				find(index)
+				continue
+			}
+			if match != "" {
+				l.set([]rune(match))
+				l.historyIndex = index
+			}
+			e.unread = &k
+			return false, nil
+		}
+	}
+}
+
+// キーを1つ読む エスケープシーケンスは1つのキーにまとめる
+func (e *Editor) readKey() (key, error) {
+	if e.unread != nil {
+		k := *e.unread
+		e.unread = nil
+		return k, nil
+	}
+
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if key(r) != escape {
+		return key(r), nil
+	}
+
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if r != '[' && r != 'O' {
+		return keyUnknown, nil
+	}
+
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch r {
+	case 'A':
+		return keyUp, nil
+	case 'B':
+		return keyDown, nil
+	case 'C':
+		return keyRight, nil
+	case 'D':
+		return keyLeft, nil
+	case 'H':
+		return keyHome, nil
+	case 'F':
+		return keyEnd, nil
+	}
+
+	if r < '0' || r > '9' {
+		return keyUnknown, nil
+	}
+
+	// ESC [ 数字 ~ の形
+	n := r
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		if r == '~' {
+			break
+		}
+		if r < '0' || r > '9' {
+			return keyUnknown, nil
+		}
+	}
+	switch n {
+	case '1', '7':
+		return keyHome, nil
+	case '4', '8':
+		return keyEnd, nil
+	case '3':
+		return keyDelete, nil
+	}
+	return keyUnknown, nil
+}
+
+// 空行と、直前と同じ行は履歴に加えない
+func (e *Editor) AddHistory(s string) {
+	if strings.TrimSpace(s) == "" {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == s {
+		return
+	}
+
+	e.history = append(e.history, s)
+	if len(e.history) > HistoryLimit {
+		e.history = e.history[len(e.history)-HistoryLimit:]
+	}
+}
+
+func (e *Editor) History() []string {
+	return e.history
+}
+
+// 1行1件の履歴を読んで加える
+func (e *Editor) ReadHistory(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		e.AddHistory(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+func (e *Editor) WriteHistory(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range e.history {
+		bw.WriteString(s)
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
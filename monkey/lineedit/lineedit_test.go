@@ -0,0 +1,205 @@
+package lineedit
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	up    = "\x1b[A"
+	down  = "\x1b[B"
+	right = "\x1b[C"
+	left  = "\x1b[D"
+	home  = "\x1b[H"
+	end   = "\x1b[4~"
+	del   = "\x1b[3~"
+)
+
+func readLine(t *testing.T, e *Editor) string {
+	t.Helper()
+
+	line, err := e.ReadLine(">> ")
+	if err != nil {
+		t.Fatalf("ReadLine error: %s", err)
+	}
+	return line
+}
+
+func TestEditing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1;\r", "let x = 1;"},
+		{"1 + 3" + "\x7f" + "2\r", "1 + 2"},
+		{"ac" + left + "b\r", "abc"},
+		{"bc" + home + "a" + end + "d\r", "abcd"},
+		{"bc\x01a\x05d\r", "abcd"},
+		{"abc" + left + left + del + "\r", "ac"},
+		{"abc\x02\x02\x04\r", "ac"},
+		{"abcd" + left + left + "\x0b\r", "ab"},
+		{"abcd" + left + left + "\x15\r", "cd"},
+		{"let foo = bar\x17baz\r", "let foo = baz"},
+		{"let foo =   \x17\x17\x17x\r", "x"},
+		{"a\x1b[5~b" + right + "\r", "ab"},
+		{"unfinished", "unfinished"},
+		{"日本" + left + "語\r", "日語本"},
+	}
+
+	for _, tt := range tests {
+		e := New(strings.NewReader(tt.input), io.Discard)
+		if got := readLine(t, e); got != tt.expected {
+			t.Errorf("input %q: wrong line. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestEndOfInput(t *testing.T) {
+	e := New(strings.NewReader("\x04"), io.Discard)
+	if _, err := e.ReadLine(">> "); err != io.EOF {
+		t.Errorf("Ctrl-D on an empty line: want io.EOF, got=%v", err)
+	}
+
+	e = New(strings.NewReader("abc\x03"), io.Discard)
+	if _, err := e.ReadLine(">> "); err != ErrInterrupted {
+		t.Errorf("Ctrl-C: want ErrInterrupted, got=%v", err)
+	}
+
+	e = New(strings.NewReader(""), io.Discard)
+	if _, err := e.ReadLine(">> "); err != io.EOF {
+		t.Errorf("empty input: want io.EOF, got=%v", err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	e := New(strings.NewReader(
+		up+"\r"+
+			up+up+"\r"+
+			up+up+up+up+down+"\r"+
+			"new"+up+down+"\r",
+	), io.Discard)
+	for _, h := range []string{"first", "second", "", "second", "third"} {
+		e.AddHistory(h)
+	}
+
+	if !reflect.DeepEqual(e.History(), []string{"first", "second", "third"}) {
+		t.Fatalf("wrong history. got=%q", e.History())
+	}
+
+	for _, want := range []string{"third", "second", "second", "new"} {
+		if got := readLine(t, e); got != want {
+			t.Errorf("wrong line. want=%q, got=%q", want, got)
+		}
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	e := New(strings.NewReader(""), io.Discard)
+	if err := e.ReadHistory(strings.NewReader("a\n\nb\nb\nc\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := e.WriteHistory(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "a\nb\nc\n" {
+		t.Errorf("wrong history file. got=%q", out.String())
+	}
+
+	for i := 0; i < HistoryLimit+10; i++ {
+		e.AddHistory(strings.Repeat("x", i+1))
+	}
+	if len(e.History()) != HistoryLimit {
+		t.Errorf("history not limited. want=%d, got=%d", HistoryLimit, len(e.History()))
+	}
+	if e.History()[0] != strings.Repeat("x", 11) {
+		t.Errorf("oldest entries should be dropped. got=%q", e.History()[0])
+	}
+}
+
+func TestReverseSearch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// 一番新しい一致
+		{"\x12let\r", "let b = 2"},
+		// もう一度 Ctrl-R で古い方へ
+		{"\x12let\x12\r", "let a = 1"},
+		// 見つからなければそれ以上遡らない
+		{"\x12let\x12\x12\x12\r", "let a = 1"},
+		// 文字を消すと新しい方から探し直す
+		{"\x12let a\x7f\x7f\r", "let b = 2"},
+		// Ctrl-G で元の行に戻る
+		{"abc\x12let\x07\r", "abc"},
+		// 他のキーを押すと、見つかった行を編集する
+		{"\x12a +" + end + "!\r", "a + b!"},
+		{"\x12a + b\x05 + 1\r", "a + b + 1"},
+	}
+
+	for _, tt := range tests {
+		e := New(strings.NewReader(tt.input), io.Discard)
+		for _, h := range []string{"let a = 1", "let b = 2", "a + b"} {
+			e.AddHistory(h)
+		}
+
+		if got := readLine(t, e); got != tt.expected {
+			t.Errorf("input %q: wrong line. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	words := []string{"let", "len", "first", "fn", "false", "format_x"}
+	complete := func(head string) (int, []string) {
+		start := strings.LastIndexAny(head, " (") + 1
+		var matches []string
+		for _, w := range words {
+			if strings.HasPrefix(w, head[start:]) {
+				matches = append(matches, w)
+			}
+		}
+		return start, matches
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+		output   string
+	}{
+		{"fir\t\r", "first", ""},
+		{"le\tt\r", "let", "let  len"},
+		{"len(fo\t\r", "len(format_x", ""},
+		{"fi\t(x)" + home + "\r", "first(x)", ""},
+		{"f\t\r", "f", "first  fn  false  format_x"},
+		{"fa" + left + "\t\r", "fa", ""},
+		{"zzz\t\r", "zzz", "\a"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		e := New(strings.NewReader(tt.input), &out)
+		e.Complete = complete
+
+		if got := readLine(t, e); got != tt.expected {
+			t.Errorf("input %q: wrong line. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+		if !strings.Contains(out.String(), tt.output) {
+			t.Errorf("input %q: output does not contain %q. got=%q", tt.input, tt.output, out.String())
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	var out bytes.Buffer
+	e := New(strings.NewReader("abc"+left+left+"\r"), &out)
+	readLine(t, e)
+
+	if !strings.Contains(out.String(), "\r>> abc\x1b[K\x1b[2D") {
+		t.Errorf("cursor not moved back. got=%q", out.String())
+	}
+}
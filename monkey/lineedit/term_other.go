@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package lineedit
+
+import "errors"
+
+// 生モードにできない環境では、常に端末ではないものとして扱う
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("lineedit: raw mode is not supported on this platform")
+}
@@ -0,0 +1,376 @@
+// コンパイル済みのバイトコードを .mkc ファイルとして保存・読み込みする
+// REPLのセッション (.mks) も同じヘッダとチェックサムで包む (session.go)
+//
+// ファイルの形
+//
+//	magic    "MKC\x00"
+//	version  uint16
+//	length   uint32   payloadのバイト数
+//	payload  命令列、ソースマップ、定数表
+//	checksum uint32   payloadのCRC-32
+//
+// 数値はcodeパッケージのオペランドと同じくビッグエンディアン
+// payloadの中の長さや個数はuvarint、整数定数はvarintで書く
+package mkc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/object"
+	"monkey/token"
+)
+
+const (
+	Magic = "MKC\x00"
+	// 形式を変えたら上げる 読み込みは同じ版しか受け付けない
+	Version = 1
+
+	headerSize = len(Magic) + 2 + 4
+)
+
+var (
+	ErrNotMKC         = errors.New("mkc: not a monkey bytecode file")
+	ErrTruncated      = errors.New("mkc: truncated file")
+	ErrChecksum       = errors.New("mkc: checksum mismatch")
+	ErrUnsupported    = errors.New("mkc: unsupported version")
+	ErrMalformed      = errors.New("mkc: malformed payload")
+	ErrUnsupportedObj = errors.New("mkc: unsupported constant")
+)
+
+// 定数の種類を表す1バイト
+const (
+	tagInteger byte = iota + 1
+	tagString
+	tagFloat
+	tagCompiledFunction
+
+	// セッションファイルにだけ現れる値
+	tagBoolean
+	tagNull
+	tagArray
+	tagHash
+	tagClosure
+	tagBuiltin
+	tagError
+)
+
+// ソースがmkcファイルかどうかを先頭のmagicで判定する
+func IsMKC(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Magic))
+}
+
+func Encode(w io.Writer, bytecode *compiler.Bytecode) error {
+	e := &encoder{}
+	e.instructions(bytecode.Instructions)
+	e.sourceMap(bytecode.SourceMap)
+
+	e.uvarint(uint64(len(bytecode.Constants)))
+	for i, c := range bytecode.Constants {
+		if err := e.constant(c); err != nil {
+			return fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+
+	return writeFile(w, Magic, Version, e.buf.Bytes())
+}
+
+func Decode(r io.Reader) (*compiler.Bytecode, error) {
+	payload, err := readFile(r, Magic, Version, ErrNotMKC)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{data: payload}
+	bytecode := &compiler.Bytecode{
+		Instructions: d.instructions(),
+		SourceMap:    d.sourceMap(),
+	}
+
+	n := d.count()
+	bytecode.Constants = make([]object.Object, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, d.constant())
+	}
+
+	if d.err == nil && d.pos != len(d.data) {
+		d.fail("%d trailing bytes", len(d.data)-d.pos)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return bytecode, nil
+}
+
+// magic、版、長さのヘッダとチェックサムでpayloadを包んで書く
+func writeFile(w io.Writer, magic string, version uint16, payload []byte) error {
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint16(header[len(magic):], version)
+	binary.BigEndian.PutUint32(header[len(magic)+2:], uint32(len(payload)))
+
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, crc32.ChecksumIEEE(payload))
+
+	bw := bufio.NewWriter(w)
+	bw.Write(header)
+	bw.Write(payload)
+	bw.Write(checksum)
+	return bw.Flush()
+}
+
+// ヘッダとチェックサムを確かめて payload を返す magic が違えば notFile を返す
+func readFile(r io.Reader, magic string, version uint16, notFile error) ([]byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, readError(err)
+	}
+
+	if !bytes.HasPrefix(header, []byte(magic)) {
+		return nil, notFile
+	}
+
+	if v := binary.BigEndian.Uint16(header[len(magic):]); v != version {
+		return nil, fmt.Errorf("%w: file is version %d, expected %d", ErrUnsupported, v, version)
+	}
+
+	// 長さをそのまま信じて確保しないように、実際に読めた分だけを使う
+	length := binary.BigEndian.Uint32(header[len(magic)+2:])
+	payload, err := io.ReadAll(io.LimitReader(r, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(payload)) != length {
+		return nil, fmt.Errorf("%w: payload has %d of %d bytes", ErrTruncated, len(payload), length)
+	}
+
+	checksum := make([]byte, 4)
+	if _, err := io.ReadFull(r, checksum); err != nil {
+		return nil, readError(err)
+	}
+	if binary.BigEndian.Uint32(checksum) != crc32.ChecksumIEEE(payload) {
+		return nil, ErrChecksum
+	}
+
+	return payload, nil
+}
+
+func readError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (e *encoder) varint(v int64) {
+	e.buf.Write(binary.AppendVarint(nil, v))
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+func (e *encoder) instructions(ins code.Instructions) {
+	e.bytes(ins)
+}
+
+func (e *encoder) sourceMap(sm code.SourceMap) {
+	e.uvarint(uint64(len(sm)))
+	for _, entry := range sm {
+		e.uvarint(uint64(entry.Offset))
+		e.string(entry.Pos.File)
+		e.uvarint(uint64(entry.Pos.Line))
+		e.uvarint(uint64(entry.Pos.Column))
+		e.uvarint(uint64(entry.Pos.Offset))
+	}
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(tagInteger)
+		e.varint(obj.Value)
+	case *object.String:
+		e.buf.WriteByte(tagString)
+		e.string(obj.Value)
+	case *object.Float:
+		e.buf.WriteByte(tagFloat)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(obj.Value))
+		e.buf.Write(b[:])
+	case *object.CompiledFunction:
+		e.buf.WriteByte(tagCompiledFunction)
+		e.instructions(obj.Instructions)
+		e.uvarint(uint64(obj.NumLocals))
+		e.uvarint(uint64(obj.NumParameters))
+		e.string(obj.Name)
+		e.sourceMap(obj.SourceMap)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedObj, obj.Type())
+	}
+	return nil
+}
+
+// 最初に失敗した時点のエラーを覚えておき、以降の読み取りはゼロ値を返す
+type decoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s at byte %d", ErrMalformed, fmt.Sprintf(format, a...), d.pos)
+	}
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail("bad uvarint")
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail("bad varint")
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+// 長さや個数として読む 残りのバイト数を超えるものは壊れている
+func (d *decoder) count() int {
+	v := d.uvarint()
+	if v > uint64(len(d.data)-d.pos) {
+		d.fail("count %d exceeds remaining %d bytes", v, len(d.data)-d.pos)
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) int() int {
+	v := d.uvarint()
+	if v > math.MaxInt32 {
+		d.fail("value %d out of range", v)
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.pos >= len(d.data) {
+		d.fail("unexpected end of payload")
+		return 0
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	if d.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, d.data[d.pos:d.pos+n])
+	d.pos += n
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) instructions() code.Instructions {
+	return code.Instructions(d.bytes())
+}
+
+func (d *decoder) sourceMap() code.SourceMap {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+
+	sm := make(code.SourceMap, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		entry := code.SourceMapEntry{Offset: d.int()}
+		entry.Pos = token.Pos{
+			File:   d.string(),
+			Line:   d.int(),
+			Column: d.int(),
+			Offset: d.int(),
+		}
+		sm = append(sm, entry)
+	}
+	return sm
+}
+
+func (d *decoder) constant() object.Object {
+	return d.constantWithTag(d.byte())
+}
+
+func (d *decoder) constantWithTag(tag byte) object.Object {
+	switch tag {
+	case tagInteger:
+		return &object.Integer{Value: d.varint()}
+	case tagString:
+		return &object.String{Value: d.string()}
+	case tagFloat:
+		if len(d.data)-d.pos < 8 {
+			d.fail("unexpected end of payload")
+			return nil
+		}
+		bits := binary.BigEndian.Uint64(d.data[d.pos:])
+		d.pos += 8
+		return &object.Float{Value: math.Float64frombits(bits)}
+	case tagCompiledFunction:
+		fn := &object.CompiledFunction{Instructions: d.instructions()}
+		fn.NumLocals = d.int()
+		fn.NumParameters = d.int()
+		fn.Name = d.string()
+		fn.SourceMap = d.sourceMap()
+		return fn
+	default:
+		if d.err == nil {
+			d.fail("unknown constant tag %d", tag)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,171 @@
+package mkc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func compile(t *testing.T, name, input string) *compiler.Bytecode {
+	t.Helper()
+
+	l := lexer.NewWithFile(name, input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%s: parser errors: %v", name, p.Errors())
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("%s: compiler error: %s", name, err)
+	}
+	return comp.Bytecode()
+}
+
+func roundTrip(t *testing.T, bytecode *compiler.Bytecode) *compiler.Bytecode {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, bytecode); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	return decoded
+}
+
+// 実行結果 エラーならそのメッセージ
+func run(bytecode *compiler.Bytecode) string {
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		return "ERROR: " + err.Error()
+	}
+
+	result := machine.LastPoppedStackElem()
+	if result == nil {
+		return ""
+	}
+	return result.Inspect()
+}
+
+func TestRoundTrip(t *testing.T) {
+	input := `let x = 1.5;
+let greet = fn(name) { "hello " + name };
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+[x * 2.0, greet("monkey"), fib(10), -9223372036854775807]`
+
+	bytecode := compile(t, "round_trip.mk", input)
+	decoded := roundTrip(t, bytecode)
+
+	if !reflect.DeepEqual(bytecode, decoded) {
+		t.Fatalf("decoded bytecode differs.\nwant=%+v\ngot =%+v", bytecode, decoded)
+	}
+
+	var fib *object.CompiledFunction
+	for _, c := range decoded.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok && fn.Name == "fib" {
+			fib = fn
+		}
+	}
+	if fib == nil || fib.NumParameters != 1 || fib.NumLocals != 1 || len(fib.SourceMap) == 0 {
+		t.Errorf("fib was not restored. got=%+v", fib)
+	}
+}
+
+// 差分テストの全プログラムで、読み直したバイトコードが同じ結果になること
+func TestDecodedBytecodeRunsIdentically(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "harness", "testdata", "*.mk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no testdata found")
+	}
+
+	for _, file := range files {
+		input, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l := lexer.NewWithFile(file, string(input))
+		p := parser.New(l)
+		program := p.ParseProgram()
+		comp := compiler.New()
+		if len(p.Errors()) != 0 || comp.Compile(program) != nil {
+			// コンパイルできないプログラムは保存もできない
+			continue
+		}
+
+		bytecode := comp.Bytecode()
+		want := run(bytecode)
+		got := run(roundTrip(t, bytecode))
+		if got != want {
+			t.Errorf("%s: decoded bytecode behaves differently.\nwant=%q\ngot =%q", file, want, got)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, compile(t, "test.mk", `let f = fn(a) { a + "!" }; f("x")`)); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	valid := buf.Bytes()
+
+	wrongVersion := append([]byte{}, valid...)
+	binary.BigEndian.PutUint16(wrongVersion[len(Magic):], Version+1)
+
+	corrupted := append([]byte{}, valid...)
+	corrupted[headerSize+1] ^= 0xff
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+		message  string
+	}{
+		{"empty", nil, ErrTruncated, "mkc: truncated file"},
+		{"header only", valid[:headerSize], ErrTruncated, ""},
+		{"half payload", valid[:headerSize+3], ErrTruncated, "mkc: truncated file: payload has 3 of"},
+		{"missing checksum", valid[:len(valid)-2], ErrTruncated, "mkc: truncated file"},
+		{"not mkc", []byte("let x = 1; let y = 2;"), ErrNotMKC, "mkc: not a monkey bytecode file"},
+		{"wrong version", wrongVersion, ErrUnsupported,
+			"mkc: unsupported version: file is version 2, expected 1"},
+		{"corrupted", corrupted, ErrChecksum, "mkc: checksum mismatch"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.data))
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong error. want=%v, got=%v", tt.name, tt.expected, err)
+			continue
+		}
+
+		if !bytes.HasPrefix([]byte(err.Error()), []byte(tt.message)) {
+			t.Errorf("%s: wrong message. want prefix %q, got=%q", tt.name, tt.message, err.Error())
+		}
+	}
+}
+
+func TestEncodeUnsupportedConstant(t *testing.T) {
+	bytecode := &compiler.Bytecode{Constants: []object.Object{&object.Boolean{Value: true}}}
+
+	err := Encode(&bytes.Buffer{}, bytecode)
+	if !errors.Is(err, ErrUnsupportedObj) {
+		t.Fatalf("wrong error. want=%v, got=%v", ErrUnsupportedObj, err)
+	}
+}
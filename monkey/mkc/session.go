@@ -0,0 +1,341 @@
+package mkc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"monkey/object"
+	"monkey/vm"
+)
+
+// REPLのセッションファイル (.mks) の形
+//
+// ヘッダとチェックサムは .mkc と同じで、magic と版だけが違う
+// payloadには、グローバル変数の名前、値の表、定数表、グローバル変数の値を順に書く
+// 値の表には定数とグローバル変数から辿れる値を1度ずつ並べ、配列やクロージャの中身は表の番号で参照する
+// 同じ値を共有していたり、自分自身を含んでいたりしても、読み込んだ後に同じ形に戻る
+const (
+	SessionMagic = "MKS\x00"
+	// 形式を変えたら上げる 読み込みは同じ版しか受け付けない
+	SessionVersion = 1
+)
+
+var ErrNotSession = errors.New("mkc: not a monkey session file")
+
+// REPLで入力を跨いで残る状態
+type Session struct {
+	// グローバル変数の名前 添字がグローバル変数の番号
+	// 上書きされたりループの中で定義されたりして、名前で参照できないものは空
+	Names     []string
+	Constants []object.Object
+	// グローバル変数の値 まだ代入されていないものは nil
+	Globals []object.Object
+}
+
+func EncodeSession(w io.Writer, session *Session) error {
+	if len(session.Globals) > len(session.Names) {
+		return fmt.Errorf("mkc: %d globals but only %d names", len(session.Globals), len(session.Names))
+	}
+
+	t := &objectTable{ids: map[object.Object]int{}}
+	for i, c := range session.Constants {
+		if err := t.add(c); err != nil {
+			return fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+	for i, g := range session.Globals {
+		if err := t.add(g); err != nil {
+			return fmt.Errorf("global %s: %w", session.Names[i], err)
+		}
+	}
+
+	e := &encoder{}
+
+	e.uvarint(uint64(len(session.Names)))
+	for _, name := range session.Names {
+		e.string(name)
+	}
+
+	e.uvarint(uint64(len(t.objects)))
+	for _, obj := range t.objects {
+		t.encode(e, obj)
+	}
+
+	e.uvarint(uint64(len(session.Constants)))
+	for _, c := range session.Constants {
+		e.uvarint(t.ref(c))
+	}
+
+	e.uvarint(uint64(len(session.Globals)))
+	for _, g := range session.Globals {
+		e.uvarint(t.ref(g))
+	}
+
+	return writeFile(w, SessionMagic, SessionVersion, e.buf.Bytes())
+}
+
+// 値に番号を振る表
+type objectTable struct {
+	ids     map[object.Object]int
+	objects []object.Object
+}
+
+// 値とその中から辿れる値を表に加える
+// 子より先に親に番号を振るので、循環していても止まる
+func (t *objectTable) add(obj object.Object) error {
+	if obj == nil {
+		return nil
+	}
+	if _, ok := t.ids[obj]; ok {
+		return nil
+	}
+
+	var children []object.Object
+	switch obj := obj.(type) {
+	case *object.Integer, *object.String, *object.Float, *object.CompiledFunction,
+		*object.Boolean, *object.Null, *object.Error:
+	case *object.Builtin:
+		if builtinName(obj) == "" {
+			return fmt.Errorf("%w: unknown builtin", ErrUnsupportedObj)
+		}
+	case *object.Array:
+		children = obj.Elements
+	case *object.Hash:
+		for _, pair := range obj.OrderedPairs() {
+			children = append(children, pair.Key, pair.Value)
+		}
+	case *object.Closure:
+		children = append([]object.Object{obj.Fn}, obj.Free...)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedObj, obj.Type())
+	}
+
+	t.ids[obj] = len(t.objects)
+	t.objects = append(t.objects, obj)
+
+	for _, child := range children {
+		if err := t.add(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 表の番号に1を足したもの 0 は nil
+func (t *objectTable) ref(obj object.Object) uint64 {
+	if obj == nil {
+		return 0
+	}
+	return uint64(t.ids[obj]) + 1
+}
+
+func (t *objectTable) refs(e *encoder, objs []object.Object) {
+	e.uvarint(uint64(len(objs)))
+	for _, obj := range objs {
+		e.uvarint(t.ref(obj))
+	}
+}
+
+// add を通った値しか来ないので失敗しない
+func (t *objectTable) encode(e *encoder, obj object.Object) {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		e.buf.WriteByte(tagBoolean)
+		if obj.Value {
+			e.buf.WriteByte(1)
+		} else {
+			e.buf.WriteByte(0)
+		}
+	case *object.Null:
+		e.buf.WriteByte(tagNull)
+	case *object.Error:
+		e.buf.WriteByte(tagError)
+		e.string(obj.Message)
+	case *object.Builtin:
+		e.buf.WriteByte(tagBuiltin)
+		e.string(builtinName(obj))
+	case *object.Array:
+		e.buf.WriteByte(tagArray)
+		t.refs(e, obj.Elements)
+	case *object.Hash:
+		e.buf.WriteByte(tagHash)
+		pairs := obj.OrderedPairs()
+		e.uvarint(uint64(len(pairs)))
+		for _, pair := range pairs {
+			e.uvarint(t.ref(pair.Key))
+			e.uvarint(t.ref(pair.Value))
+		}
+	case *object.Closure:
+		e.buf.WriteByte(tagClosure)
+		e.uvarint(t.ref(obj.Fn))
+		t.refs(e, obj.Free)
+	default:
+		e.constant(obj)
+	}
+}
+
+// 組み込み関数は番号が変わっても読めるように名前で書く
+func builtinName(b *object.Builtin) string {
+	for _, def := range object.Builtins {
+		if def.Builtin == b {
+			return def.Name
+		}
+	}
+	return ""
+}
+
+func DecodeSession(r io.Reader) (*Session, error) {
+	payload, err := readFile(r, SessionMagic, SessionVersion, ErrNotSession)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{data: payload}
+	session := &Session{}
+
+	n := d.count()
+	session.Names = make([]string, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		session.Names = append(session.Names, d.string())
+	}
+
+	objects := d.objects()
+
+	n = d.count()
+	session.Constants = make([]object.Object, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		session.Constants = append(session.Constants, d.deref(objects))
+	}
+
+	n = d.count()
+	if n > len(session.Names) {
+		d.fail("%d globals but only %d names", n, len(session.Names))
+	}
+	session.Globals = make([]object.Object, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		session.Globals = append(session.Globals, d.deref(objects))
+	}
+
+	if d.err == nil && d.pos != len(d.data) {
+		d.fail("%d trailing bytes", len(d.data)-d.pos)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return session, nil
+}
+
+// 中身を後で埋める値と、その中身の番号
+type pendingObject struct {
+	obj  object.Object
+	refs []uint64
+}
+
+// 値の表を読む
+// 後ろの値を参照していることもあるので、先に全ての値を作ってから中身を繋ぐ
+func (d *decoder) objects() []object.Object {
+	n := d.count()
+	objects := make([]object.Object, 0, n)
+	var pending []pendingObject
+
+	refs := func(n int) []uint64 {
+		r := make([]uint64, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			r = append(r, d.uvarint())
+		}
+		return r
+	}
+
+	for i := 0; i < n && d.err == nil; i++ {
+		var obj object.Object
+
+		switch tag := d.byte(); tag {
+		case tagBoolean:
+			if d.byte() != 0 {
+				obj = vm.True
+			} else {
+				obj = vm.False
+			}
+		case tagNull:
+			obj = vm.Null
+		case tagError:
+			obj = &object.Error{Message: d.string()}
+		case tagBuiltin:
+			name := d.string()
+			for _, def := range object.Builtins {
+				if def.Name == name {
+					obj = def.Builtin
+				}
+			}
+			if obj == nil && d.err == nil {
+				d.fail("unknown builtin %q", name)
+			}
+		case tagArray:
+			obj = &object.Array{}
+			pending = append(pending, pendingObject{obj, refs(d.count())})
+		case tagHash:
+			obj = object.NewHash()
+			pending = append(pending, pendingObject{obj, refs(2 * d.count())})
+		case tagClosure:
+			obj = &object.Closure{}
+			fn := d.uvarint()
+			pending = append(pending, pendingObject{obj, append([]uint64{fn}, refs(d.count())...)})
+		default:
+			obj = d.constantWithTag(tag)
+		}
+
+		objects = append(objects, obj)
+	}
+
+	for _, p := range pending {
+		if d.err != nil {
+			break
+		}
+
+		values := make([]object.Object, len(p.refs))
+		for i, ref := range p.refs {
+			values[i] = d.lookup(objects, ref)
+		}
+
+		switch obj := p.obj.(type) {
+		case *object.Array:
+			obj.Elements = values
+		case *object.Hash:
+			for i := 0; i < len(values); i += 2 {
+				key, ok := values[i].(object.Hashable)
+				if !ok {
+					d.fail("unusable hash key")
+					break
+				}
+				obj.Set(key.HashKey(), object.HashPair{Key: values[i], Value: values[i+1]})
+			}
+		case *object.Closure:
+			fn, ok := values[0].(*object.CompiledFunction)
+			if !ok {
+				d.fail("closure over a non-function")
+				break
+			}
+			obj.Fn = fn
+			obj.Free = values[1:]
+		}
+	}
+
+	return objects
+}
+
+// 値の表の番号を読んで、その値を返す
+func (d *decoder) deref(objects []object.Object) object.Object {
+	return d.lookup(objects, d.uvarint())
+}
+
+func (d *decoder) lookup(objects []object.Object, ref uint64) object.Object {
+	if ref == 0 {
+		return nil
+	}
+	if ref > uint64(len(objects)) {
+		d.fail("object %d out of range", ref-1)
+		return nil
+	}
+	return objects[ref-1]
+}
@@ -0,0 +1,139 @@
+package mkc
+
+import (
+	"bytes"
+	"errors"
+	"monkey/code"
+	"monkey/object"
+	"monkey/vm"
+	"testing"
+)
+
+func sessionRoundTrip(t *testing.T, session *Session) *Session {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := EncodeSession(&buf, session); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	decoded, err := DecodeSession(&buf)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	return decoded
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	fn := &object.CompiledFunction{
+		Instructions:  code.Make(code.OpReturn),
+		NumLocals:     1,
+		NumParameters: 1,
+		Name:          "f",
+	}
+	shared := &object.String{Value: "shared"}
+
+	hash := object.NewHash()
+	for _, key := range []object.Object{&object.String{Value: "b"}, &object.Integer{Value: 1}, vm.True} {
+		hash.Set(key.(object.Hashable).HashKey(), object.HashPair{Key: key, Value: shared})
+	}
+
+	// 自分自身を含む配列と、自分自身を自由変数に持つクロージャ
+	cyclic := &object.Array{}
+	cyclic.Elements = []object.Object{cyclic, vm.Null}
+	closure := &object.Closure{Fn: fn}
+	closure.Free = []object.Object{closure, nil}
+
+	session := &Session{
+		Names:     []string{"h", "cyclic", "closure", "len", "err", "unset", "f"},
+		Constants: []object.Object{&object.Integer{Value: -7}, fn, &object.Float{Value: 0.5}},
+		Globals: []object.Object{
+			hash,
+			cyclic,
+			closure,
+			object.Builtins[0].Builtin,
+			&object.Error{Message: "boom"},
+			nil,
+			vm.False,
+		},
+	}
+
+	decoded := sessionRoundTrip(t, session)
+
+	if len(decoded.Names) != len(session.Names) {
+		t.Fatalf("wrong names. got=%q", decoded.Names)
+	}
+	for i, name := range session.Names {
+		if decoded.Names[i] != name {
+			t.Errorf("wrong name %d. want=%q, got=%q", i, name, decoded.Names[i])
+		}
+	}
+
+	decodedFn, ok := decoded.Constants[1].(*object.CompiledFunction)
+	if !ok || decodedFn.Name != "f" || !bytes.Equal(decodedFn.Instructions, fn.Instructions) {
+		t.Errorf("wrong function constant. got=%+v", decoded.Constants[1])
+	}
+
+	h, ok := decoded.Globals[0].(*object.Hash)
+	if !ok || h.Inspect() != hash.Inspect() {
+		t.Fatalf("wrong hash. got=%v", decoded.Globals[0])
+	}
+	// 同じ値を共有したまま戻る
+	pairs := h.OrderedPairs()
+	if pairs[0].Value != pairs[1].Value {
+		t.Errorf("shared value was copied")
+	}
+	if pairs[2].Key != vm.True {
+		t.Errorf("boolean key is not vm.True")
+	}
+
+	a, ok := decoded.Globals[1].(*object.Array)
+	if !ok || a.Elements[0] != a || a.Elements[1] != vm.Null {
+		t.Errorf("cyclic array not restored. got=%+v", decoded.Globals[1])
+	}
+
+	c, ok := decoded.Globals[2].(*object.Closure)
+	if !ok || c.Fn != decodedFn || c.Free[0] != c || c.Free[1] != nil {
+		t.Errorf("closure not restored. got=%+v", decoded.Globals[2])
+	}
+
+	if decoded.Globals[3] != object.Builtins[0].Builtin {
+		t.Errorf("builtin not restored. got=%+v", decoded.Globals[3])
+	}
+	if e, ok := decoded.Globals[4].(*object.Error); !ok || e.Message != "boom" {
+		t.Errorf("error not restored. got=%+v", decoded.Globals[4])
+	}
+	if decoded.Globals[5] != nil || decoded.Globals[6] != vm.False {
+		t.Errorf("wrong globals. got=%+v", decoded.Globals[5:])
+	}
+}
+
+func TestSessionErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeSession(&buf, &Session{Names: []string{"x"}, Globals: []object.Object{&object.Integer{Value: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	valid := append([]byte{}, buf.Bytes()...)
+
+	if _, err := DecodeSession(bytes.NewReader(valid[:len(valid)-1])); !errors.Is(err, ErrTruncated) {
+		t.Errorf("truncated: wrong error. got=%v", err)
+	}
+
+	// .mkc を読もうとした
+	buf.Reset()
+	Encode(&buf, compile(t, "x.mk", "1"))
+	if _, err := DecodeSession(&buf); !errors.Is(err, ErrNotSession) {
+		t.Errorf("mkc file: wrong error. got=%v", err)
+	}
+
+	newer := append([]byte{}, valid...)
+	newer[len(SessionMagic)+1] = SessionVersion + 1
+	if _, err := DecodeSession(bytes.NewReader(newer)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("newer version: wrong error. got=%v", err)
+	}
+
+	err := EncodeSession(&buf, &Session{Names: []string{"f"}, Globals: []object.Object{&object.Function{}}})
+	if !errors.Is(err, ErrUnsupportedObj) {
+		t.Errorf("evaluator function: wrong error. got=%v", err)
+	}
+}
@@ -1,5 +1,7 @@
 package object
 
+import "sort"
+
 func NewEncloseEnviroment(outer *Enviroment) *Enviroment {
 	env := NewEnviroment()
 	// outerが外部スコープ
@@ -7,6 +9,20 @@ func NewEncloseEnviroment(outer *Enviroment) *Enviroment {
 	return env
 }
 
+// 関数を呼び出したときの環境 nameはletで束縛された関数の名前
+func NewFunctionEnviroment(outer *Enviroment, name string) *Enviroment {
+	env := NewEncloseEnviroment(outer)
+	env.function = name
+	return env
+}
+
+// ループの本体のように、関数の中で名前の有効範囲だけを区切る環境
+func NewBlockEnviroment(outer *Enviroment) *Enviroment {
+	env := NewEncloseEnviroment(outer)
+	env.block = true
+	return env
+}
+
 func NewEnviroment() *Enviroment {
 	s := make(map[string]Object)
 	return &Enviroment{store: s}
@@ -15,6 +31,9 @@ func NewEnviroment() *Enviroment {
 type Enviroment struct {
 	store map[string]Object
 	outer *Enviroment
+	block bool
+	// 呼び出し中の関数の名前 本体の中ではこの名前は関数そのものを指し、書き換えられない
+	function string
 }
 
 func (e *Enviroment) Get(name string) (Object, bool) {
@@ -25,7 +44,48 @@ func (e *Enviroment) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
+// すでに定義されている変数を、定義された環境で書き換える
+// 書き換えられるのは、今の関数の中かグローバル環境で定義された変数だけ
+// 外側の関数の変数はVMでは値のコピーしか持っていないので、こちらでも書き換えさせない
+// 関数の本体から、その関数自身の名前にも代入させない (VMでも関数そのものを指す名前になる)
+// 書き換えられなければfalseを返す
+func (e *Enviroment) Assign(name string, val Object) (Object, bool) {
+	outsideFunction := false
+
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			if outsideFunction && env.outer != nil {
+				return nil, false
+			}
+
+			env.store[name] = val
+			return val, true
+		}
+
+		if env.function != "" && env.function == name {
+			return nil, false
+		}
+
+		if !env.block {
+			outsideFunction = true
+		}
+	}
+
+	return nil, false
+}
+
 func (e *Enviroment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// この環境で定義された名前を辞書順に返す 外側の環境は含めない
+func (e *Enviroment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
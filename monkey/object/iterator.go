@@ -0,0 +1,68 @@
+package object
+
+import "fmt"
+
+const ITERATOR_OBJ = "ITERATOR"
+
+// for-inで配列・ハッシュ・文字列を順番にたどるためのオブジェクト
+// 作成した時点の内容を写し取るので、ループ中に元の値が変わっても影響を受けない
+type Iterator struct {
+	keys   []Object
+	values []Object
+	index  int
+
+	// 変数が1つの場合に、キーと値のどちらを渡すか
+	// ハッシュはキー、配列と文字列は要素を渡す
+	singleIsKey bool
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string {
+	return fmt.Sprintf("Iterator[%d/%d]", it.index, len(it.keys))
+}
+
+// 反復できない値ならfalseを返却する
+func NewIterator(obj Object) (*Iterator, bool) {
+	it := &Iterator{}
+
+	switch obj := obj.(type) {
+	case *Array:
+		for i, el := range obj.Elements {
+			it.keys = append(it.keys, &Integer{Value: int64(i)})
+			it.values = append(it.values, el)
+		}
+	case *Hash:
+		for _, pair := range obj.OrderedPairs() {
+			it.keys = append(it.keys, pair.Key)
+			it.values = append(it.values, pair.Value)
+		}
+		it.singleIsKey = true
+	case *String:
+		// 1文字ずつ。インデックスは文字単位で数える
+		for i, r := range []rune(obj.Value) {
+			it.keys = append(it.keys, &Integer{Value: int64(i)})
+			it.values = append(it.values, &String{Value: string(r)})
+		}
+	default:
+		return nil, false
+	}
+
+	return it, true
+}
+
+// 次の要素を返却する。numVarsはループ変数の数で、1ならvalueだけが意味を持つ
+func (it *Iterator) Next(numVars int) (key, value Object, ok bool) {
+	if it.index >= len(it.keys) {
+		return nil, nil, false
+	}
+
+	key = it.keys[it.index]
+	value = it.values[it.index]
+	it.index++
+
+	if numVars == 1 && it.singleIsKey {
+		return nil, key, true
+	}
+
+	return key, value, true
+}
@@ -6,6 +6,7 @@ import (
 	"hash/fnv"
 	"monkey/ast"
 	"monkey/code"
+	"strconv"
 	"strings"
 )
 
@@ -13,6 +14,7 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ           = "INTEGER"
+	FLOAT_OBJ             = "FLOAT"
 	BOOLEAN_OBJ           = "BOOLEAN"
 	NULL_OBJ              = "NULL"
 	RETURN_VALUE_OBJ      = "RETURN_VALUE"
@@ -23,6 +25,7 @@ const (
 	ARRAY_OBJ             = "ARRAY"
 	HASH_OBJ              = "HASH"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
+	CLOSURE_OBJ           = "CLOSURE"
 )
 
 type Object interface {
@@ -37,6 +40,21 @@ type Integer struct {
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
+type Float struct {
+	Value float64
+}
+
+// 読み直したときに同じ値の小数になるように表示する
+// 2.0を"2"と表示すると整数として読まれてしまうので、".0"を補う
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if strings.ContainsAny(s, ".eIN") {
+		return s
+	}
+	return s + ".0"
+}
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
 type Boolean struct {
 	Value bool
 }
@@ -67,6 +85,8 @@ type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Enviroment
+	// let f = fn(){}のように束縛された場合の名前
+	Name string
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -130,6 +150,34 @@ type HashPair struct {
 
 type Hash struct {
 	Pairs map[HashKey]HashPair
+	// キーが追加された順番。for-inやInspectはこの順番で並ぶ
+	Order []HashKey
+}
+
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
+}
+
+// キーを追加する。既にあるキーなら値だけ差し替え、順番は変えない
+func (h *Hash) Set(key HashKey, pair HashPair) {
+	if _, ok := h.Pairs[key]; !ok {
+		h.Order = append(h.Order, key)
+	}
+
+	h.Pairs[key] = pair
+}
+
+// 追加された順番に並べたペアを返却する
+func (h *Hash) OrderedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+
+	for _, key := range h.Order {
+		if pair, ok := h.Pairs[key]; ok {
+			pairs = append(pairs, pair)
+		}
+	}
+
+	return pairs
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
@@ -164,7 +212,7 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.OrderedPairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			pair.Key.Inspect(), pair.Value.Inspect()))
 	}
@@ -181,8 +229,13 @@ type Hashable interface {
 }
 
 type CompiledFunction struct {
-	Instructions code.Instructions
-	NumLocals    int
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	// letで束縛された関数ならその名前 無名関数なら空
+	Name string
+	// 命令の位置からソース上の位置を引く表 スタックトレースに使う
+	SourceMap code.SourceMap
 }
 
 func (cf *CompiledFunction) Type() ObjectType {
@@ -192,3 +245,14 @@ func (cf *CompiledFunction) Type() ObjectType {
 func (cf *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompledFunction[%p]", cf)
 }
+
+// 関数と、その関数が捕捉した自由変数をまとめたもの
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
@@ -18,3 +18,66 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with diffrent content have some hash keys")
 	}
 }
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{2, "2.0"},
+		{-0.25, "-0.25"},
+		{0.001, "0.001"},
+		{1e21, "1e+21"},
+		{1e-7, "1e-07"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %g. want=%q, got=%q",
+				tt.value, tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	hash := NewHash()
+
+	keys := []*String{{Value: "b"}, {Value: "a"}, {Value: "c"}}
+	for i, key := range keys {
+		hash.Set(key.HashKey(), HashPair{Key: key, Value: &Integer{Value: int64(i)}})
+	}
+	hash.Set(keys[0].HashKey(), HashPair{Key: keys[0], Value: &Integer{Value: 9}})
+
+	if hash.Inspect() != "{b: 9, a: 1, c: 2}" {
+		t.Errorf("hash has wrong order. got=%q", hash.Inspect())
+	}
+}
+
+func TestIterator(t *testing.T) {
+	array := &Array{Elements: []Object{&Integer{Value: 5}, &Integer{Value: 6}}}
+
+	it, ok := NewIterator(array)
+	if !ok {
+		t.Fatalf("array is not iterable")
+	}
+
+	key, value, ok := it.Next(2)
+	if !ok || key.Inspect() != "0" || value.Inspect() != "5" {
+		t.Errorf("wrong first element. got=%v, %v", key, value)
+	}
+
+	_, value, ok = it.Next(1)
+	if !ok || value.Inspect() != "6" {
+		t.Errorf("wrong second element. got=%v", value)
+	}
+
+	if _, _, ok := it.Next(1); ok {
+		t.Errorf("iterator was not exhausted")
+	}
+
+	if _, ok := NewIterator(&Integer{Value: 1}); ok {
+		t.Errorf("integer should not be iterable")
+	}
+}
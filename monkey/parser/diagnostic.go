@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"fmt"
+	"monkey/token"
+)
+
+// 診断の重大度
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// 診断コード
+// 一度決めた番号は意味を変えずに使い続ける
+const (
+	CodeUnexpectedToken        = "P001"
+	CodeNoPrefixParseFn        = "P002"
+	CodeInvalidInteger         = "P003"
+	CodeInvalidFloat           = "P004"
+	CodeInvalidAssignTarget    = "P005"
+	CodeLoopControlOutsideLoop = "P006"
+)
+
+// 構文解析中に見つかった問題1件分
+// Pos から End の手前までが問題のある範囲
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Message  string
+	Pos      token.Pos
+	End      token.Pos
+	// 直し方の手がかり 無ければ空
+	Hint string
+}
+
+// "行:列: error[P001]: メッセージ" の形にする ヒントがあれば次の行に続ける
+func (d Diagnostic) String() string {
+	s := fmt.Sprintf("%s: %s[%s]: %s", d.Pos, d.Severity, d.Code, d.Message)
+	if d.Hint != "" {
+		s += "\n\thint: " + d.Hint
+	}
+	return s
+}
+
+// トークンが終わる位置 (最後の文字の次)
+func tokenEnd(tok token.Token) token.Pos {
+	if !tok.Pos.IsValid() {
+		return tok.Pos
+	}
+
+	width := len(tok.Literal)
+	if tok.Type == token.STRING {
+		// 両端の " の分
+		width += 2
+	}
+
+	end := tok.Pos
+	end.Column += width
+	end.Offset += width
+	return end
+}
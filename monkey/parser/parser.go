@@ -12,6 +12,9 @@ import (
 const (
 	_int = iota //　⇒　0始まり
 	LOWSET
+	ASSIGNMENT  // = または +=
+	LOGICAL_OR  // ||
+	LOGICAL_AND // &&
 	EQUALS      // ==
 	LESSGREATER // > または <
 	SUM         // +
@@ -30,17 +33,33 @@ type (
 )
 
 // precedences(+や-のトークンの集まり)
+// ビット演算の優先度はGoと同じで、| と ^ は + と、% と & と シフト は * と同じ強さ
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGNMENT,
+	token.PLUS_ASSIGN:     ASSIGNMENT,
+	token.MINUS_ASSIGN:    ASSIGNMENT,
+	token.ASTERISK_ASSIGN: ASSIGNMENT,
+	token.SLASH_ASSIGN:    ASSIGNMENT,
+	token.OR:              LOGICAL_OR,
+	token.AND:             LOGICAL_AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.BIT_OR:          SUM,
+	token.BIT_XOR:         SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.BIT_AND:         PRODUCT,
+	token.SHIFT_LEFT:      PRODUCT,
+	token.SHIFT_RIGHT:     PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 // Parser
@@ -52,7 +71,12 @@ type Parser struct {
 	//  先読みToken
 	peekToken token.Token
 	// エラー処理用
-	errors []string
+	diagnostics []Diagnostic
+	// エラーを出した文の後始末中かどうか
+	// trueの間は後続のエラーを記録せず、文の区切りまで読み飛ばす
+	panicking bool
+	// 今解析しているループの深さ 関数リテラルに入ると0から数え直す
+	loopDepth int
 
 	// 構文解析関数
 	prefixParseFns map[token.TokenType]prefixParseFn
@@ -63,13 +87,14 @@ type Parser struct {
 func New(l *lexer.Lexer) *Parser {
 	// パーサーに字句解析で使う構造体を仕込む
 	p := &Parser{l: l,
-		errors: []string{},
+		diagnostics: []Diagnostic{},
 	}
 
 	//　前置型構文関数の初期化
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
@@ -87,12 +112,27 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_LEFT, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_RIGHT, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 
 	// 最初のpeekTokenには値が保持されていないため
 	// ２回呼び出して
@@ -110,15 +150,70 @@ func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// 構文解析中に記録した診断
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
+}
+
 // Error処理
+// 診断を "行:列: メッセージ" の文字列にしたもの
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := make([]string, 0, len(p.diagnostics))
+	for _, d := range p.diagnostics {
+		if d.Severity != SeverityError {
+			continue
+		}
+		errors = append(errors, fmt.Sprintf("%s: %s", d.Pos, d.Message))
+	}
+	return errors
+}
+
+// 診断を記録して、その文の後始末モードに入る
+// 後始末中に出たエラーは最初のエラーの巻き添えなので捨てる
+func (p *Parser) report(d Diagnostic) {
+	if p.panicking {
+		return
+	}
+	p.diagnostics = append(p.diagnostics, d)
+	p.panicking = true
+}
+
+// トークン1つ分を範囲とするエラーを記録する
+func (p *Parser) errorAt(tok token.Token, code, hint, format string, a ...interface{}) {
+	p.report(Diagnostic{
+		Severity: SeverityError,
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Pos:      tok.Pos,
+		End:      tokenEnd(tok),
+		Hint:     hint,
+	})
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token no be %s, got %s instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	var hint string
+	switch {
+	case p.peekTokenIs(token.EOF):
+		hint = "the input ended in the middle of a statement"
+	case t == token.RPAREN || t == token.RBRACE:
+		hint = fmt.Sprintf("is a closing %s missing?", t)
+	case t == token.IDENT && p.curTokenIs(token.LET):
+		hint = "let must be followed by a variable name"
+	}
+
+	p.errorAt(p.peekToken, CodeUnexpectedToken, hint,
+		"expected next token to be %s, got %s instead", t, p.peekToken.Type)
+}
+
+// エラーを出した文の残りを読み飛ばし、 ; か } で立ち直る
+// 1行の書き間違いから後ろの文までエラーが連鎖しないようにする
+func (p *Parser) synchronize() {
+	for !p.curTokenIs(token.SEMICOLON) &&
+		!p.curTokenIs(token.RBRACE) &&
+		!p.curTokenIs(token.EOF) {
+		p.nextToken()
+	}
+	p.panicking = false
 }
 
 // 先読みTokenと今読みTokenを１つずつすすめる
@@ -163,8 +258,11 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse fuction for %s found", t)
-	p.errors = append(p.errors, msg)
+	var hint string
+	if t == token.ILLEGAL {
+		hint = fmt.Sprintf("unrecognized character %q", p.curToken.Literal)
+	}
+	p.errorAt(p.curToken, CodeNoPrefixParseFn, hint, "no prefix parse fuction for %s found", t)
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -204,10 +302,11 @@ func (p *Parser) ParseProgram() *ast.Program {
 	for p.curToken.Type != token.EOF {
 
 		// letなのかifなのかfuncなのか　それに対する「木」が帰ってくる
-		// letやifなどのキーワードが先頭にあった場合だけエラーを発報するようになっている
-		// なので一度エラーがおきても引き続き連鎖で全てがエラーになることはない
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.panicking {
+			// エラーが出た文は木に入れず、文の区切りまで読み飛ばす
+			p.synchronize()
+		} else if stmt != nil {
 			// 木を追加する
 			program.Statements = append(program.Statements, stmt)
 		}
@@ -230,6 +329,14 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
 	// 式
 	default:
 		return p.parseExpressionStatement()
@@ -259,6 +366,11 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWSET)
 
+	// 関数リテラルなら、束縛先の名前を覚えさせておく
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -281,6 +393,130 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+// while (条件) { 処理 } を解析する
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWSET)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// for (x in 式) { 処理 } と for (k, v in 式) { 処理 } を解析する
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variables = append(stmt.Variables,
+		&ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Variables = append(stmt.Variables,
+			&ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWSET)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// ループの本体を解析する 中ではbreakとcontinueが使える
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+
+	return p.parseBlockStatement()
+}
+
+// breakとcontinueはループの中でしか使えない
+// 関数はその外側のループを操作できないので、関数の本体の中ではループを数え直している
+func (p *Parser) checkLoopControl() {
+	if p.loopDepth > 0 || p.panicking {
+		return
+	}
+
+	// 文の形は正しく木も作れるので、後始末モードには入らない
+	p.diagnostics = append(p.diagnostics, Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeLoopControlOutsideLoop,
+		Message:  fmt.Sprintf("%s outside of loop", p.curToken.Literal),
+		Pos:      p.curToken.Pos,
+		End:      tokenEnd(p.curToken),
+		Hint:     "break and continue can only be used inside a while or for loop of the same function",
+	})
+}
+
+func (p *Parser) parseBreakStatement() ast.Statement {
+	stmt := &ast.BreakStatement{Token: p.curToken}
+	p.checkLoopControl()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() ast.Statement {
+	stmt := &ast.ContinueStatement{Token: p.curToken}
+	p.checkLoopControl()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 // hoge; low;のような式文の解析
 // Expression_Statemntノードの作成
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
@@ -348,8 +584,21 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	// 文字列　⇒　値
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorAt(p.curToken, CodeInvalidInteger, "", "could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.errorAt(p.curToken, CodeInvalidFloat, "", "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 
@@ -484,7 +733,14 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
 		// 新たにノードを構築する
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.panicking {
+			// エラーが出た文は木に入れず、文の区切りまで読み飛ばす
+			// } で止まったときはそれがブロックの終わりなので進めない
+			p.synchronize()
+			if p.curTokenIs(token.RBRACE) {
+				continue
+			}
+		} else if stmt != nil {
 			// ノードを追加していく
 			block.Statements = append(block.Statements, stmt)
 		}
@@ -513,6 +769,11 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	// 関数の本体から外側のループは操作できない
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = loopDepth }()
+
 	// ごついStatementノードが刺さる
 	lit.Body = p.parseBlockStatement()
 
@@ -656,6 +917,36 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// x = 式 や arr[i] += 式 を解析する
+// 代入先は識別子か添字式だけ
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	exp := &ast.AssignExpression{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: p.curToken.Literal,
+	}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.report(Diagnostic{
+			Severity: SeverityError,
+			Code:     CodeInvalidAssignTarget,
+			Message:  fmt.Sprintf("invalid assignment target: %s", target.String()),
+			Pos:      target.Pos(),
+			End:      p.curToken.Pos,
+			Hint:     "only variables and index expressions can be assigned to",
+		})
+		return nil
+	}
+
+	p.nextToken()
+	// a = b = 1 を a = (b = 1) と読むために、右辺は1つ低い優先度で解析する
+	exp.Value = p.parseExpression(ASSIGNMENT - 1)
+
+	return exp
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
 	// makeはhashを作ることもできる
@@ -673,6 +964,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		value := p.parseExpression(LOWSET)
 
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
 		}
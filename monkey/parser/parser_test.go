@@ -221,6 +221,34 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5;", 1.5},
+		{".5;", 0.5},
+		{"1e-3;", 0.001},
+		{"2E+2;", 200},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -354,6 +382,42 @@ func TestOpreatorPrecedenceParsiong(t *testing.T) {
 			"-a * b",
 			"((-a) * b)",
 		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a + b % c",
+			"(a + (b % c))",
+		},
+		{
+			"a | b & c ^ d",
+			"((a | (b & c)) ^ d)",
+		},
+		{
+			"a << 2 + 1",
+			"((a << 2) + 1)",
+		},
+		{
+			"a & 1 == 0",
+			"((a & 1) == 0)",
+		},
+		{
+			"a == b && c != d",
+			"((a == b) && (c != d))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"x = a || b",
+			"(x = (a || b))",
+		},
 		{
 			"!-a",
 			"(!(-a))",
@@ -1037,3 +1101,365 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Body does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got=%T",
+			program.Statements[0])
+	}
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.FunctionLiteral. got=%T", stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Fatalf("function literal name wrong. want 'myFunction', got=%q\n",
+			function.Name)
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; continue; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testInfixExpresison(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 3 {
+		t.Fatalf("body is not 3 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+
+	body, ok := stmt.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Statements[0] is not ast.ExpressionStatement. got=%T",
+			stmt.Body.Statements[0])
+	}
+
+	if !testIdentifier(t, body.Expression, "x") {
+		return
+	}
+
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("Statements[1] is not ast.BreakStatement. got=%T",
+			stmt.Body.Statements[1])
+	}
+
+	if _, ok := stmt.Body.Statements[2].(*ast.ContinueStatement); !ok {
+		t.Errorf("Statements[2] is not ast.ContinueStatement. got=%T",
+			stmt.Body.Statements[2])
+	}
+
+	if program.String() != "while(x < y) xbreak;continue;" {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedVariables []string
+		expectedString    string
+	}{
+		{"for (x in arr) { x }", []string{"x"}, "for(x in arr) x"},
+		{"for (k, v in hash) { v }", []string{"k", "v"}, "for(k, v in hash) v"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+				1, len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ForStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T",
+				program.Statements[0])
+		}
+
+		if len(stmt.Variables) != len(tt.expectedVariables) {
+			t.Fatalf("wrong number of variables. want=%d, got=%d",
+				len(tt.expectedVariables), len(stmt.Variables))
+		}
+
+		for i, name := range tt.expectedVariables {
+			testIdentifier(t, stmt.Variables[i], name)
+		}
+
+		if len(stmt.Body.Statements) != 1 {
+			t.Errorf("body is not 1 statements. got=%d\n", len(stmt.Body.Statements))
+		}
+
+		if program.String() != tt.expectedString {
+			t.Errorf("program.String() wrong. want=%q, got=%q",
+				tt.expectedString, program.String())
+		}
+	}
+}
+
+func TestHashLiteralKeepsKeyOrder(t *testing.T) {
+	input := `{"two": 2, "one": 1, "three": 3}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+	}
+
+	expected := []string{"two", "one", "three"}
+	if len(hash.Keys) != len(expected) {
+		t.Fatalf("hash.Keys has wrong length. got=%d", len(hash.Keys))
+	}
+
+	for i, key := range hash.Keys {
+		if key.String() != expected[i] {
+			t.Errorf("key %d wrong. want=%q, got=%q", i, expected[i], key.String())
+		}
+	}
+}
+
+func TestAssignExpression(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedOperator string
+		expectedString   string
+	}{
+		{"x = 5;", "=", "(x = 5)"},
+		{"x += 1 * 2;", "+=", "(x += (1 * 2))"},
+		{"x -= 1;", "-=", "(x -= 1)"},
+		{"x *= 2;", "*=", "(x *= 2)"},
+		{"x /= 2;", "/=", "(x /= 2)"},
+		{"arr[1] = 2;", "=", "((arr[1] = 2)"},
+		{`h["a"] += 1;`, "+=", "((h[a] += 1)"},
+		{"a = b = 1;", "=", "(a = (b = 1))"},
+		{"x = y == 1;", "=", "(x = (y == 1))"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+				1, len(program.Statements))
+		}
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.AssignExpression)
+		if !ok {
+			t.Fatalf("exp is not ast.AssignExpression. got=%T", stmt.Expression)
+		}
+
+		if exp.Operator != tt.expectedOperator {
+			t.Errorf("exp.Operator is not %q. got=%q", tt.expectedOperator, exp.Operator)
+		}
+
+		if program.String() != tt.expectedString {
+			t.Errorf("program.String() wrong. want=%q, got=%q",
+				tt.expectedString, program.String())
+		}
+	}
+}
+
+func TestInvalidAssignTarget(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"1 = 2;", "1:1: invalid assignment target: 1"},
+		{"f() = 2;", "1:1: invalid assignment target: f()"},
+		{"a + b = 2;", "1:1: invalid assignment target: (a + b)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser error for %q", tt.input)
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"let = 5;", "1:5: expected next token to be IDENT, got = instead"},
+		{"let x 5;", "1:7: expected next token to be =, got INT instead"},
+		{"let x = 1;\nif (x { x }", "2:7: expected next token to be ), got { instead"},
+		{"x;\n  a + b = 2;", "2:3: invalid assignment target: (a + b)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser error for %q", tt.input)
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		code     string
+		pos      string
+		end      string
+		hasHint  bool
+		expected string
+	}{
+		{"let = 5;", CodeUnexpectedToken, "1:5", "1:6", true,
+			"1:5: error[P001]: expected next token to be IDENT, got = instead\n\thint: let must be followed by a variable name"},
+		{"if (x { x }", CodeUnexpectedToken, "1:7", "1:8", true,
+			"1:7: error[P001]: expected next token to be ), got { instead\n\thint: is a closing ) missing?"},
+		{"1 + ;", CodeNoPrefixParseFn, "1:5", "1:6", false,
+			"1:5: error[P002]: no prefix parse fuction for ; found"},
+		{"a + b = 2;", CodeInvalidAssignTarget, "1:1", "1:7", true,
+			"1:1: error[P005]: invalid assignment target: (a + b)\n\thint: only variables and index expressions can be assigned to"},
+		{"while (true) { fn() { break; } }", CodeLoopControlOutsideLoop, "1:23", "1:28", true,
+			"1:23: error[P006]: break outside of loop\n\thint: break and continue can only be used inside a while or for loop of the same function"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Fatalf("expected 1 diagnostic for %q, got=%d %v", tt.input, len(diagnostics), diagnostics)
+		}
+
+		d := diagnostics[0]
+		if d.Severity != SeverityError {
+			t.Errorf("wrong severity. want=%s, got=%s", SeverityError, d.Severity)
+		}
+		if d.Code != tt.code {
+			t.Errorf("wrong code. want=%s, got=%s", tt.code, d.Code)
+		}
+		if d.Pos.String() != tt.pos || d.End.String() != tt.end {
+			t.Errorf("wrong range. want=%s-%s, got=%s-%s", tt.pos, tt.end, d.Pos, d.End)
+		}
+		if (d.Hint != "") != tt.hasHint {
+			t.Errorf("wrong hint for %q. got=%q", tt.input, d.Hint)
+		}
+		if d.String() != tt.expected {
+			t.Errorf("wrong string.\nwant=%q\ngot =%q", tt.expected, d.String())
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     []string
+		expectedStatements []string
+	}{
+		{
+			"let = 5; let y = 10; y",
+			[]string{"1:5: expected next token to be IDENT, got = instead"},
+			[]string{"let y = 10;", "y"},
+		},
+		{
+			"let x 5 + * 2;\nlet y = ;\nlet z = 3;",
+			[]string{
+				"1:7: expected next token to be =, got INT instead",
+				"2:9: no prefix parse fuction for ; found",
+			},
+			[]string{"let z = 3;"},
+		},
+		{
+			"fn(x) { x + ; x * 2 }; 1",
+			[]string{"1:13: no prefix parse fuction for ; found"},
+			[]string{"fn(x) (x * 2)", "1"},
+		},
+		{
+			"if (x) { let } 7",
+			[]string{"1:14: expected next token to be IDENT, got } instead"},
+			[]string{"ifx ", "7"},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Fatalf("wrong number of errors for %q. want=%d, got=%d %q",
+				tt.input, len(tt.expectedErrors), len(errors), errors)
+		}
+		for i, want := range tt.expectedErrors {
+			if errors[i] != want {
+				t.Errorf("wrong error %d. want=%q, got=%q", i, want, errors[i])
+			}
+		}
+
+		if len(program.Statements) != len(tt.expectedStatements) {
+			t.Fatalf("wrong number of statements for %q. want=%d, got=%d %q",
+				tt.input, len(tt.expectedStatements), len(program.Statements), program.String())
+		}
+		for i, want := range tt.expectedStatements {
+			if got := program.Statements[i].String(); got != want {
+				t.Errorf("wrong statement %d. want=%q, got=%q", i, want, got)
+			}
+		}
+	}
+}
@@ -4,64 +4,544 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"monkey/ast"
 	"monkey/compiler"
+	"monkey/disasm"
+	"monkey/evaluator"
 	"monkey/lexer"
+	"monkey/lineedit"
+	"monkey/mkc"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/token"
 	"monkey/vm"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 const PROMPT = ">> "
 
+// カッコが閉じていない間、続きの行を読むときのプロンプト
+const CONTINUE_PROMPT = ".. "
+
+// ホームディレクトリに置く履歴のファイル
+const HISTORY_FILE = ".monkey_history"
+
+// 補完に使うコマンド名
+var COMMANDS = []string{":ast", ":bytecode", ":globals", ":help", ":load", ":quit", ":reset", ":restore", ":save"}
+
+const HELP = `:ast              show the AST of the last input
+:bytecode         show the bytecode of the last input (vm only)
+:globals          list the global variables
+:reset            forget all variables and start over
+:load <file>      run a file in this session
+:save <file>      save the variables of this session (vm only)
+:restore <file>   replace this session with a saved one (vm only)
+:quit             leave the REPL
+:help             show this message
+`
+
+// 入力を跨いで残る状態
+type session struct {
+	out     io.Writer
+	useEval bool
+
+	// VMで実行するときの状態
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+
+	// 評価器で実行するときの状態
+	env *object.Enviroment
+
+	// :ast と :bytecode で見せる、最後に実行した入力
+	lastProgram  *ast.Program
+	lastBytecode *compiler.Bytecode
+	// lastBytecode の定数のうち、最後の入力で増えたものの先頭
+	firstNewConstant int
+
+	quit bool
+}
+
+func newSession(out io.Writer, useEval bool) *session {
+	s := &session{out: out, useEval: useEval}
+	s.reset()
+	return s
+}
+
+func (s *session) reset() {
+	s.constants = []object.Object{}
+	s.globals = make([]object.Object, vm.GlobalsSize)
+	s.symbolTable = compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		s.symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	s.env = object.NewEnviroment()
+
+	s.lastProgram = nil
+	s.lastBytecode = nil
+	s.firstNewConstant = 0
+}
+
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+	newSession(out, false).start(in)
+}
+
+// コンパイラとVMを使わず、木をたどる評価器で実行する
+func StartEval(in io.Reader, out io.Writer) {
+	newSession(out, true).start(in)
+}
 
-	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
+// path のセッションがあれば読み込んでから始め、終わるときに path へ保存する
+// 読み込めなかったときは、ファイルを上書きしないように始めずにエラーを返す
+func StartSession(in io.Reader, out io.Writer, path string) error {
+	s := newSession(out, false)
+	if _, err := os.Stat(path); err == nil {
+		if err := s.restore(path); err != nil {
+			return err
+		}
+	}
+
+	s.start(in)
+
+	if err := s.save(path); err != nil {
+		fmt.Fprintf(out, "%s\n", err)
+	}
+	return nil
+}
+
+// 1行ずつ入力を読むもの 端末なら lineedit.Editor、そうでなければ bufio.Scanner で読む
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+type scannerReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (r *scannerReader) ReadLine(prompt string) (string, error) {
+	fmt.Fprintf(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+func (s *session) start(in io.Reader) {
+	out := s.out
+	defer object.SetOutput(object.SetOutput(out))
+
+	var reader lineReader = &scannerReader{scanner: bufio.NewScanner(in), out: out}
+	if editor, ok := newEditor(in, out); ok {
+		editor.Complete = s.complete
+		loadHistory(editor)
+		defer saveHistory(editor)
+		reader = editor
+	}
+
+	// まだカッコが閉じていない入力
+	var pending []string
 
 	for {
-		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
+		prompt := PROMPT
+		if len(pending) > 0 {
+			prompt = CONTINUE_PROMPT
+		}
 
-		if !scanned {
-			return
+		line, err := reader.ReadLine(prompt)
+		if err == lineedit.ErrInterrupted {
+			pending = nil
+			continue
+		}
+		if err != nil {
+			break
 		}
 
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
+		if editor, ok := reader.(*lineedit.Editor); ok {
+			editor.AddHistory(line)
+		}
 
-		program := p.ParseProgram()
+		if len(pending) == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			s.command(strings.TrimSpace(line))
+			if s.quit {
+				return
+			}
+			continue
+		}
 
-		if len(p.Errors()) != 0 {
-			fmt.Print("parseError")
+		pending = append(pending, line)
+		input := strings.Join(pending, "\n")
+		if openBrackets(input) > 0 {
 			continue
 		}
 
-		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
+		pending = nil
+		s.execute(input)
+	}
+
+	// 閉じないまま入力が終わったら、そこまでを実行して構文エラーを見せる
+	if len(pending) > 0 {
+		io.WriteString(out, "\n")
+		s.execute(strings.Join(pending, "\n"))
+	}
+}
+
+// 入力と出力がどちらも端末のときだけ行編集を使う
+func newEditor(in io.Reader, out io.Writer) (*lineedit.Editor, bool) {
+	inFile, ok := in.(*os.File)
+	if !ok {
+		return nil, false
+	}
+	outFile, ok := out.(*os.File)
+	if !ok {
+		return nil, false
+	}
+	return lineedit.NewTerminal(inFile, outFile)
+}
+
+// 履歴はホームディレクトリの HISTORY_FILE に残す
+func historyPath() (string, bool) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(home, HISTORY_FILE), true
+}
+
+func loadHistory(editor *lineedit.Editor) {
+	path, ok := historyPath()
+	if !ok {
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	editor.ReadHistory(f)
+}
+
+func saveHistory(editor *lineedit.Editor) {
+	path, ok := historyPath()
+	if !ok {
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	editor.WriteHistory(f)
+}
+
+// カーソルの前の単語を、キーワードと今定義されている名前から補完する
+// 行頭の : の後ろはコマンド名を補完する
+func (s *session) complete(head string) (int, []string) {
+	trimmed := strings.TrimLeft(head, " \t")
+	if strings.HasPrefix(trimmed, ":") && !strings.ContainsAny(trimmed, " \t") {
+		return len(head) - len(trimmed), withPrefix(COMMANDS, trimmed)
+	}
+
+	start := len(head)
+	for start > 0 && isIdentChar(head[start-1]) {
+		start--
+	}
+	word := head[start:]
+	if word == "" || isDigit(word[0]) {
+		return start, nil
+	}
+
+	names := token.Keywords()
+	if s.useEval {
+		names = append(names, s.env.Names()...)
+		for _, b := range object.Builtins {
+			names = append(names, b.Name)
+		}
+	} else {
+		names = append(names, s.symbolTable.Names()...)
+	}
+
+	return start, withPrefix(names, word)
+}
 
+// prefix で始まる語を、重複を除いて辞書順に返す
+func withPrefix(words []string, prefix string) []string {
+	seen := map[string]bool{}
+	var matches []string
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) && !seen[w] {
+			seen[w] = true
+			matches = append(matches, w)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func isIdentChar(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' || isDigit(ch)
+}
+
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+// 開いたまま閉じていないカッコの数
+func openBrackets(input string) int {
+	l := lexer.New(input)
+	depth := 0
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		}
+	}
+
+	return depth
+}
+
+// : で始まる行を実行する
+func (s *session) command(line string) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case ":ast":
+		if s.lastProgram == nil {
+			io.WriteString(s.out, "nothing has been entered yet\n")
+			return
+		}
+		for _, stmt := range s.lastProgram.Statements {
+			io.WriteString(s.out, stmt.String())
+			io.WriteString(s.out, "\n")
+		}
+	case ":bytecode":
+		s.showBytecode()
+	case ":globals":
+		s.showGlobals()
+	case ":reset":
+		s.reset()
+		io.WriteString(s.out, "session reset\n")
+	case ":load":
+		if arg == "" {
+			io.WriteString(s.out, "usage: :load <file>\n")
+			return
+		}
+		data, err := os.ReadFile(arg)
 		if err != nil {
-			fmt.Fprintf(out, "Woops! compilation failed:\n %s\n", err)
+			fmt.Fprintf(s.out, "%s\n", err)
+			return
+		}
+		s.execute(string(data))
+	case ":save", ":restore":
+		if arg == "" {
+			fmt.Fprintf(s.out, "usage: %s <file>\n", name)
+			return
+		}
+		if s.useEval {
+			io.WriteString(s.out, "sessions can only be saved with the vm engine\n")
+			return
+		}
+
+		if name == ":save" {
+			if err := s.save(arg); err != nil {
+				fmt.Fprintf(s.out, "%s\n", err)
+				return
+			}
+			fmt.Fprintf(s.out, "saved session to %s\n", arg)
+		} else {
+			if err := s.restore(arg); err != nil {
+				fmt.Fprintf(s.out, "%s\n", err)
+				return
+			}
+			fmt.Fprintf(s.out, "restored %d names from %s\n", len(s.symbolTable.Definitions()), arg)
+		}
+	case ":quit", ":q":
+		s.quit = true
+	case ":help":
+		io.WriteString(s.out, HELP)
+	default:
+		fmt.Fprintf(s.out, "unknown command %s (type :help for a list)\n", name)
+	}
+}
+
+func (s *session) showBytecode() {
+	if s.useEval {
+		io.WriteString(s.out, "bytecode is only available with the vm engine\n")
+		return
+	}
+	if s.lastBytecode == nil {
+		io.WriteString(s.out, "nothing has been compiled yet\n")
+		return
+	}
+
+	io.WriteString(s.out, disasm.Instructions(s.lastBytecode.Instructions, s.lastBytecode.Constants))
+
+	// 最後の入力で定義された関数だけを見せる
+	for i := s.firstNewConstant; i < len(s.lastBytecode.Constants); i++ {
+		fn, ok := s.lastBytecode.Constants[i].(*object.CompiledFunction)
+		if !ok {
 			continue
 		}
 
-		code := comp.Bytecode()
-		constants = code.Constants
-		machine := vm.NewWithGlobalsStore(code, globals)
+		name := fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		fmt.Fprintf(s.out, "\nfn %s (constant %d):\n", name, i)
+		io.WriteString(s.out, disasm.Instructions(fn.Instructions, s.lastBytecode.Constants))
+	}
+}
 
-		err = machine.Run()
-		if err != nil {
-			fmt.Fprintf(out, "Woops! Excuting bytecode failed:\n %s\n", err)
+func (s *session) showGlobals() {
+	if s.useEval {
+		for _, name := range s.env.Names() {
+			value, _ := s.env.Get(name)
+			fmt.Fprintf(s.out, "%s = %s\n", name, value.Inspect())
+		}
+		return
+	}
+
+	for _, symbol := range s.symbolTable.Definitions() {
+		value := s.globals[symbol.Index]
+		if value == nil {
+			// コンパイルはされたが、実行が途中で止まった
+			fmt.Fprintf(s.out, "%s (unset)\n", symbol.Name)
 			continue
 		}
+		fmt.Fprintf(s.out, "%s = %s\n", symbol.Name, value.Inspect())
+	}
+}
+
+// 入力1つ分を構文解析して実行し、結果を表示する
+func (s *session) execute(input string) {
+	l := lexer.New(input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		printParserDiagnostics(s.out, p.Diagnostics())
+		return
+	}
+	s.lastProgram = program
+
+	if s.useEval {
+		evaluated := evaluator.Eval(program, s.env)
+		if evaluated != nil {
+			io.WriteString(s.out, evaluated.Inspect())
+			io.WriteString(s.out, "\n")
+		}
+		return
+	}
+
+	comp := compiler.NewWithState(s.symbolTable, s.constants)
+	err := comp.Compile(program)
+
+	if err != nil {
+		fmt.Fprintf(s.out, "Woops! compilation failed:\n %s\n", err)
+		return
+	}
+
+	code := comp.Bytecode()
+	s.firstNewConstant = len(s.constants)
+	s.constants = code.Constants
+	s.lastBytecode = code
+	machine := vm.NewWithGlobalsStore(code, s.globals)
+
+	err = machine.Run()
+	if err != nil {
+		fmt.Fprintf(s.out, "Woops! Excuting bytecode failed:\n %s\n", err)
+		if runtimeErr, ok := err.(*vm.RuntimeError); ok {
+			io.WriteString(s.out, runtimeErr.StackTrace())
+		}
+		return
+	}
+
+	lastPopped := machine.LastPoppedStackElem()
+	if lastPopped != nil {
+		io.WriteString(s.out, lastPopped.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+}
+
+// 構文エラーを位置とヒント付きで1件ずつ表示する
+func printParserDiagnostics(out io.Writer, diagnostics []parser.Diagnostic) {
+	for _, d := range diagnostics {
+		io.WriteString(out, d.String())
+		io.WriteString(out, "\n")
+	}
+}
+
+// 名前、定数表、グローバル変数の値を path に書き出す
+func (s *session) save(path string) error {
+	n := s.symbolTable.NumDefinitions()
+	session := &mkc.Session{
+		Names:     make([]string, n),
+		Constants: s.constants,
+		Globals:   make([]object.Object, n),
+	}
+	// 上書きされた変数やループの中の変数は名前で参照できないので、名前を空にしておく
+	for _, symbol := range s.symbolTable.Definitions() {
+		session.Names[symbol.Index] = symbol.Name
+	}
+	// for文の反復子はループを抜けたら使われないので保存しない
+	for i, g := range s.globals[:n] {
+		if _, ok := g.(*object.Iterator); !ok {
+			session.Globals[i] = g
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := mkc.EncodeSession(f, session); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// path のセッションで今の状態を置き換える
+func (s *session) restore(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	session, err := mkc.DecodeSession(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
 
-		lastPopped := machine.LastPoppedStackElem()
-		if lastPopped != nil {
-			io.WriteString(out, lastPopped.Inspect())
-			io.WriteString(out, "\n")
+	s.reset()
+	for _, name := range session.Names {
+		// グローバル変数の番号は定義した順に振られるので、同じ順に定義すれば同じ番号になる
+		if name == "" {
+			s.symbolTable.Reserve()
+		} else {
+			s.symbolTable.Define(name)
 		}
 	}
+	s.constants = session.Constants
+	copy(s.globals, session.Globals)
 
+	return nil
 }
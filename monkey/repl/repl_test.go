@@ -0,0 +1,277 @@
+package repl
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpenBrackets(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"1 + 2", 0},
+		{"let f = fn(x) {", 1},
+		{"[1, [2,", 2},
+		{"{1: (2", 2},
+		{"f(1))", -1},
+		{`"(" + "{"`, 0},
+	}
+
+	for _, tt := range tests {
+		if got := openBrackets(tt.input); got != tt.expected {
+			t.Errorf("openBrackets(%q) wrong. want=%d, got=%d", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		useEval  bool
+		expected []string
+	}{
+		{
+			"multi-line function",
+			"let add = fn(a, b) {\n  a + b\n};\nadd(1,\n  2)\n",
+			false,
+			[]string{">> .. .. ", ">> .. 3\n"},
+		},
+		{
+			"multi-line with the evaluator",
+			"let a = [1,\n2];\na\n",
+			true,
+			[]string{">> .. >> [1, 2]\n"},
+		},
+		{
+			"parser diagnostics",
+			"let = 3\n",
+			false,
+			[]string{"1:5: error[P001]: expected next token to be IDENT, got = instead\n\thint: let must be followed by a variable name\n"},
+		},
+		{
+			"unclosed input at the end",
+			"let a = [1,\n",
+			false,
+			[]string{">> .. \n1:12: error[P002]"},
+		},
+		{
+			"puts writes to the output",
+			"puts(\"hi\")\n",
+			false,
+			[]string{">> hi\nnull\n"},
+		},
+		{
+			"puts with the evaluator",
+			"puts(\"hi\")\n",
+			true,
+			[]string{">> hi\nnull\n"},
+		},
+		{
+			":ast",
+			"1 + 2 * 3\n:ast\n",
+			false,
+			[]string{"(1 + (2 * 3))\n"},
+		},
+		{
+			":bytecode",
+			"let f = fn(x) { x };\nf(2)\n:bytecode\n",
+			false,
+			[]string{"0000 OpGetGlobal 0\n0003 OpConstant 1             ; 2\n0006 OpCall 1\n0008 OpPop\n"},
+		},
+		{
+			":bytecode shows functions from the last input",
+			"fn(x) { x }(1)\n:bytecode\n",
+			false,
+			[]string{"fn <anonymous> (constant 0):\n0000 OpGetLocal 0\n0002 OpReturnValue\n"},
+		},
+		{
+			":bytecode with the evaluator",
+			"1\n:bytecode\n",
+			true,
+			[]string{"bytecode is only available with the vm engine\n"},
+		},
+		{
+			":globals",
+			"let a = 1; let b = \"two\";\nlet c = 1 / 0;\n:globals\n",
+			false,
+			[]string{">> a = 1\nb = two\nc (unset)\n"},
+		},
+		{
+			":globals with the evaluator",
+			"let b = 2; let a = 1;\n:globals\n",
+			true,
+			[]string{">> a = 1\nb = 2\n"},
+		},
+		{
+			":reset",
+			"let a = 1;\n:reset\na\n",
+			false,
+			[]string{"session reset\n", "undefined variable a"},
+		},
+		{
+			":quit",
+			":quit\n1 + 1\n",
+			false,
+			[]string{">> "},
+		},
+		{
+			"unknown command",
+			":nope\n",
+			false,
+			[]string{"unknown command :nope (type :help for a list)\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if tt.useEval {
+			StartEval(strings.NewReader(tt.input), &out)
+		} else {
+			Start(strings.NewReader(tt.input), &out)
+		}
+
+		for _, want := range tt.expected {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s: output does not contain %q.\ngot=%q", tt.name, want, out.String())
+			}
+		}
+
+		if tt.name == ":quit" && strings.Contains(out.String(), "2") {
+			t.Errorf(":quit: input after :quit was run. got=%q", out.String())
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lib.mk")
+	if err := os.WriteFile(file, []byte("let double = fn(x) {\n  x * 2\n};\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	Start(strings.NewReader(":load "+file+"\ndouble(21)\n:load missing.mk\n:load\n"), &out)
+
+	for _, want := range []string{"42\n", "missing.mk", "usage: :load <file>\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q.\ngot=%q", want, out.String())
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	s := newSession(io.Discard, false)
+	s.execute("let length = 3; let lemon = fn() { let local = 1; local };")
+
+	tests := []struct {
+		head     string
+		useEval  bool
+		start    int
+		expected []string
+	}{
+		{"le", false, 0, []string{"lemon", "len", "length", "let"}},
+		{"1 + leng", false, 4, []string{"length"}},
+		{"f", false, 0, []string{"false", "first", "fn", "for"}},
+		{"loc", false, 0, nil},
+		{"x1 + ", false, 5, nil},
+		{"12", false, 0, nil},
+		{":", false, 0, COMMANDS},
+		{"  :res", false, 2, []string{":reset", ":restore"}},
+		{":sa", false, 0, []string{":save"}},
+		{":load le", false, 6, []string{"lemon", "len", "length", "let"}},
+	}
+
+	for _, tt := range tests {
+		start, got := s.complete(tt.head)
+		if start != tt.start || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("complete(%q) wrong. want=%d %q, got=%d %q", tt.head, tt.start, tt.expected, start, got)
+		}
+	}
+
+	s = newSession(io.Discard, true)
+	s.execute("let length = 3;")
+	if _, got := s.complete("len"); !reflect.DeepEqual(got, []string{"len", "length"}) {
+		t.Errorf("complete with the evaluator wrong. got=%q", got)
+	}
+}
+
+func TestSaveAndRestore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "session.mks")
+
+	var out bytes.Buffer
+	Start(strings.NewReader(
+		"let double = fn(x) { x * 2 };\n"+
+			"let counter = fn() { let n = 10; fn() { n + 1 } }();\n"+
+			"let data = {\"xs\": [1, 2, 3], \"f\": double};\n"+
+			"let last = 1;\n"+
+			"for (x in [1]) { let inner = x; }\n"+
+			"let last = last + 1;\n"+
+			":save "+file+"\n",
+	), &out)
+	if !strings.Contains(out.String(), "saved session to "+file+"\n") {
+		t.Fatalf("session not saved. got=%q", out.String())
+	}
+
+	out.Reset()
+	Start(strings.NewReader(
+		"let other = 1;\n"+
+			":restore "+file+"\n"+
+			"data[\"f\"](counter()) + len(data[\"xs\"]) + last\n"+
+			"other\n"+
+			"let more = double(4);\n"+
+			"more\n",
+	), &out)
+
+	for _, want := range []string{"restored 4 names from " + file + "\n", ">> 27\n", "undefined variable other", ">> 8\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q.\ngot=%q", want, out.String())
+		}
+	}
+
+	out.Reset()
+	StartEval(strings.NewReader(":save "+file+"\n:restore "+file+"\n"), &out)
+	if strings.Count(out.String(), "sessions can only be saved with the vm engine\n") != 2 {
+		t.Errorf("evaluator should refuse sessions. got=%q", out.String())
+	}
+
+	out.Reset()
+	Start(strings.NewReader(":restore "+filepath.Join(t.TempDir(), "missing.mks")+"\n:save\n"), &out)
+	for _, want := range []string{"missing.mks: no such file or directory\n", "usage: :save <file>\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q.\ngot=%q", want, out.String())
+		}
+	}
+}
+
+func TestStartSession(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "session.mks")
+
+	var out bytes.Buffer
+	if err := StartSession(strings.NewReader("let a = [1, 2];\n:quit\n"), &out, file); err != nil {
+		t.Fatalf("StartSession error: %s", err)
+	}
+
+	out.Reset()
+	if err := StartSession(strings.NewReader("push(a, 3)\n"), &out, file); err != nil {
+		t.Fatalf("StartSession error: %s", err)
+	}
+	if !strings.Contains(out.String(), "[1, 2, 3]\n") {
+		t.Errorf("session not restored. got=%q", out.String())
+	}
+
+	// 読めないファイルは上書きしない
+	bad := filepath.Join(t.TempDir(), "bad.mks")
+	os.WriteFile(bad, []byte("not a session"), 0o644)
+	if err := StartSession(strings.NewReader("1\n"), &out, bad); err == nil {
+		t.Errorf("expected an error for a broken session file")
+	}
+	if data, _ := os.ReadFile(bad); string(data) != "not a session" {
+		t.Errorf("broken session file was overwritten")
+	}
+}
@@ -1,5 +1,10 @@
 package token
 
+import (
+	"fmt"
+	"sort"
+)
+
 // stringのalias
 type TokenType string
 
@@ -7,6 +12,33 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Pos
+}
+
+// ソース上の位置 LineとColumnは1始まり、Offsetは先頭からのバイト数
+type Pos struct {
+	File   string
+	Line   int
+	Column int
+	Offset int
+}
+
+// 行が0の位置は不明として扱う
+func (p Pos) IsValid() bool { return p.Line > 0 }
+
+// file:line:column の形にする エディタでそのまま飛べるように
+func (p Pos) String() string {
+	s := p.File
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
 }
 
 // TokenType
@@ -17,6 +49,7 @@ const (
 	// 識別子 + リテラル
 	IDENT = "IDENT"
 	INT   = "INT"
+	FLOAT = "FLOAT"
 
 	// 演算子
 	ASSIGN   = "="
@@ -27,9 +60,27 @@ const (
 	SLASH    = "/"
 	EQ       = "=="
 	NOT_EQ   = "!="
+	AND      = "&&"
+	OR       = "||"
+
+	// 複合代入
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+
+	LT      = "<"
+	GT      = ">"
+	LT_EQ   = "<="
+	GT_EQ   = ">="
+	PERCENT = "%"
 
-	LT = "<"
-	GT = ">"
+	// ビット演算
+	BIT_AND     = "&"
+	BIT_OR      = "|"
+	BIT_XOR     = "^"
+	SHIFT_LEFT  = "<<"
+	SHIFT_RIGHT = ">>"
 
 	// デリミタ
 	COMMA     = ","
@@ -51,6 +102,11 @@ const (
 	IF       = "if"
 	ELSE     = "else"
 	RETURN   = "return"
+	WHILE    = "while"
+	BREAK    = "break"
+	CONTINUE = "continue"
+	FOR      = "for"
+	IN       = "in"
 
 	// 追加対応
 	STRING = "STRING"
@@ -61,13 +117,18 @@ const (
 // let foobarなど特別な意味をもつ文字列を、これを使って処理する
 // TokenTypeとつながってる（ただのstringエイリアス）
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"for":      FOR,
+	"in":       IN,
 }
 
 // 　定義しておいた特別な意味をもつ文字列なのか、どうか検証する
@@ -81,3 +142,13 @@ func LookupIdent(ident string) TokenType {
 	// なければIDENTを返却
 	return IDENT
 }
+
+// キーワードを辞書順に返す REPLの補完で使う
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+	for w := range keywords {
+		words = append(words, w)
+	}
+	sort.Strings(words)
+	return words
+}
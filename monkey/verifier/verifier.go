@@ -0,0 +1,396 @@
+// 実行する前にバイトコードを検査する
+//
+// VMは渡された命令列を信じて実行するので、ファイルから読んだバイトコードはここで確かめてから渡す
+// 命令列ごとに、オペランドが範囲内か、ジャンプ先が命令の先頭か、
+// どの経路で来てもスタックの深さが同じで足りなくならないかを調べる
+package verifier
+
+import (
+	"fmt"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/object"
+	"monkey/token"
+	"monkey/vm"
+)
+
+// 検査に通らなかった命令
+type Error struct {
+	// 命令列の名前 メインは "<main>"
+	Function string
+	Offset   int
+	// ソースマップがあれば元のソースの位置
+	Pos     token.Pos
+	Message string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s at %04d: %s", e.Function, e.Offset, e.Message)
+	if e.Pos.IsValid() {
+		msg = fmt.Sprintf("%s: %s", e.Pos, msg)
+	}
+	return msg
+}
+
+// 検査した命令列1本分の結果
+type Function struct {
+	Name string
+	// 定数表の番号 メインは -1
+	Constant int
+	// 実行中に積まれる値の最大数 (ローカル変数の分は含まない)
+	MaxStack int
+}
+
+// 命令1つ分
+type instruction struct {
+	offset   int
+	op       code.Opcode
+	def      *code.Definition
+	operands []int
+}
+
+// 検査中の命令列
+type function struct {
+	Function
+	ins          code.Instructions
+	numLocals    int
+	sourceMap    code.SourceMap
+	instructions []instruction
+	// 命令の先頭の位置から instructions の添字を引く
+	index map[int]int
+	// 何個の自由変数と一緒にクロージャにされるか 分からなければ -1
+	numFree int
+}
+
+type verifier struct {
+	constants []object.Object
+	functions []*function
+	// どのOpClosureよりも多くない自由変数の数 OpPatchFreeで書き換えられる範囲
+	maxFree int
+}
+
+// メインと定数表の中の全ての関数を検査し、それぞれの最大スタック深さを返す
+// 最初に見つかった問題をエラーとして返す
+func Verify(bytecode *compiler.Bytecode) ([]Function, error) {
+	v := &verifier{constants: bytecode.Constants}
+
+	v.functions = append(v.functions, &function{
+		Function:  Function{Name: "<main>", Constant: -1},
+		ins:       bytecode.Instructions,
+		sourceMap: bytecode.SourceMap,
+		numFree:   0,
+	})
+
+	for i, c := range bytecode.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		name := fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		v.functions = append(v.functions, &function{
+			Function:  Function{Name: fmt.Sprintf("%s (constant %d)", name, i), Constant: i},
+			ins:       fn.Instructions,
+			numLocals: fn.NumLocals,
+			sourceMap: fn.SourceMap,
+			numFree:   -1,
+		})
+	}
+
+	// 自由変数の数はクロージャを作る側の命令で決まるので、先に全部を読んでおく
+	for _, fn := range v.functions {
+		if err := fn.decode(); err != nil {
+			return nil, err
+		}
+	}
+	if err := v.collectClosures(); err != nil {
+		return nil, err
+	}
+
+	result := make([]Function, len(v.functions))
+	for i, fn := range v.functions {
+		if err := v.checkOperands(fn); err != nil {
+			return nil, err
+		}
+		if err := fn.checkStack(); err != nil {
+			return nil, err
+		}
+
+		if fn.numLocals+fn.MaxStack > vm.StackSize {
+			return nil, fn.errorf(0, "needs %d stack slots, more than the VM's %d",
+				fn.numLocals+fn.MaxStack, vm.StackSize)
+		}
+		result[i] = fn.Function
+	}
+
+	return result, nil
+}
+
+func (fn *function) errorf(offset int, format string, a ...interface{}) *Error {
+	pos, _ := fn.sourceMap.Lookup(offset)
+	return &Error{
+		Function: fn.Name,
+		Offset:   offset,
+		Pos:      pos,
+		Message:  fmt.Sprintf(format, a...),
+	}
+}
+
+// 命令列を命令に分ける 読めないバイトや途中で切れた命令があれば失敗
+func (fn *function) decode() error {
+	fn.index = map[int]int{}
+
+	for i := 0; i < len(fn.ins); {
+		def, err := code.Lookup(fn.ins[i])
+		if err != nil {
+			return fn.errorf(i, "%s", err)
+		}
+		if i+def.Width() > len(fn.ins) {
+			return fn.errorf(i, "%s is truncated", def.Name)
+		}
+
+		operands, read := code.ReadOperands(def, fn.ins[i+1:])
+		fn.index[i] = len(fn.instructions)
+		fn.instructions = append(fn.instructions, instruction{
+			offset:   i,
+			op:       code.Opcode(fn.ins[i]),
+			def:      def,
+			operands: operands,
+		})
+
+		i += 1 + read
+	}
+
+	if fn.Constant >= 0 && len(fn.instructions) == 0 {
+		return fn.errorf(0, "function has no instructions")
+	}
+
+	return nil
+}
+
+// OpClosure から、関数ごとの自由変数の数を決める
+func (v *verifier) collectClosures() error {
+	byConstant := map[int]*function{}
+	for _, fn := range v.functions {
+		if fn.Constant >= 0 {
+			byConstant[fn.Constant] = fn
+		}
+	}
+
+	for _, fn := range v.functions {
+		for _, ins := range fn.instructions {
+			if ins.op != code.OpClosure {
+				continue
+			}
+
+			index, numFree := ins.operands[0], ins.operands[1]
+			target, ok := byConstant[index]
+			if !ok {
+				if index >= len(v.constants) {
+					return fn.errorf(ins.offset, "constant %d out of range (pool size %d)", index, len(v.constants))
+				}
+				return fn.errorf(ins.offset, "OpClosure on constant %d, which is %s, not a function",
+					index, v.constants[index].Type())
+			}
+
+			if target.numFree >= 0 && target.numFree != numFree {
+				return fn.errorf(ins.offset, "closure over constant %d has %d free variables, elsewhere %d",
+					index, numFree, target.numFree)
+			}
+			target.numFree = numFree
+			if numFree > v.maxFree {
+				v.maxFree = numFree
+			}
+		}
+	}
+
+	return nil
+}
+
+func (v *verifier) checkOperands(fn *function) error {
+	for _, ins := range fn.instructions {
+		switch ins.op {
+		case code.OpConstant:
+			if ins.operands[0] >= len(v.constants) {
+				return fn.errorf(ins.offset, "constant %d out of range (pool size %d)",
+					ins.operands[0], len(v.constants))
+			}
+		case code.OpGetLocal, code.OpSetLocal:
+			if ins.operands[0] >= fn.numLocals {
+				return fn.errorf(ins.offset, "%s %d out of range (NumLocals %d)",
+					ins.def.Name, ins.operands[0], fn.numLocals)
+			}
+		case code.OpGetFree:
+			if fn.numFree >= 0 && ins.operands[0] >= fn.numFree {
+				return fn.errorf(ins.offset, "free variable %d out of range (%d free variables)",
+					ins.operands[0], fn.numFree)
+			}
+		case code.OpGetBuiltin:
+			if ins.operands[0] >= len(object.Builtins) {
+				return fn.errorf(ins.offset, "builtin %d out of range (%d builtins)",
+					ins.operands[0], len(object.Builtins))
+			}
+		case code.OpHash:
+			if ins.operands[0]%2 != 0 {
+				return fn.errorf(ins.offset, "OpHash with an odd number of elements: %d", ins.operands[0])
+			}
+		case code.OpIterNext:
+			if n := ins.operands[1]; n != 1 && n != 2 {
+				return fn.errorf(ins.offset, "OpIterNext with %d loop variables, want 1 or 2", n)
+			}
+		case code.OpPatchFree:
+			// どのクロージャを書き換えるかは実行するまで分からないので、一番多いものと比べる
+			if ins.operands[0] >= v.maxFree {
+				return fn.errorf(ins.offset, "free variable %d out of range (at most %d free variables)",
+					ins.operands[0], v.maxFree)
+			}
+		case code.OpReturn, code.OpReturnValue:
+			if fn.Constant < 0 {
+				return fn.errorf(ins.offset, "%s outside of a function", ins.def.Name)
+			}
+		}
+
+		if code.IsJump(ins.op) {
+			target := ins.operands[0]
+			if _, ok := fn.index[target]; !ok && target != len(fn.ins) {
+				return fn.errorf(ins.offset, "%s target %04d is not the start of an instruction",
+					ins.def.Name, target)
+			}
+		}
+	}
+
+	return nil
+}
+
+// 命令がスタックから取る値の数と積む値の数
+func stackEffect(ins instruction) (int, int, bool) {
+	switch ins.op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree,
+		code.OpCurrentClosure:
+		return 0, 1, true
+	case code.OpPop, code.OpSetGlobal, code.OpSetLocal,
+		code.OpJumpNotTruthy, code.OpJumpTruthy, code.OpReturnValue:
+		return 1, 0, true
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan,
+		code.OpLessEqual, code.OpGreaterEqual, code.OpIndex:
+		return 2, 1, true
+	case code.OpMinus, code.OpBang, code.OpIterInit:
+		return 1, 1, true
+	case code.OpJump, code.OpReturn:
+		return 0, 0, true
+	case code.OpArray, code.OpHash:
+		return ins.operands[0], 1, true
+	case code.OpCall:
+		return ins.operands[0] + 1, 1, true
+	case code.OpClosure:
+		return ins.operands[1], 1, true
+	case code.OpPatchFree:
+		return 2, 0, true
+	case code.OpSetIndex:
+		return 3, 1, true
+	case code.OpDup:
+		return 1, 2, true
+	case code.OpDup2:
+		return 2, 4, true
+	case code.OpIterNext:
+		// 続けるときの数 抜けるときはイテレータを取るだけ
+		return 1, ins.operands[1], true
+	}
+
+	return 0, 0, false
+}
+
+// 命令の後に実行が続くか
+func fallsThrough(op code.Opcode) bool {
+	switch op {
+	case code.OpJump, code.OpReturn, code.OpReturnValue:
+		return false
+	}
+	return true
+}
+
+// 入口から辿れる全ての経路でスタックの深さを計算する
+// 同じ命令に違う深さで来る経路があれば失敗
+func (fn *function) checkStack() error {
+	depth := make([]int, len(fn.instructions)+1)
+	for i := range depth {
+		depth[i] = -1
+	}
+
+	// 命令列の末尾は instructions の最後の次として扱う
+	indexOf := func(offset int) int {
+		if offset == len(fn.ins) {
+			return len(fn.instructions)
+		}
+		return fn.index[offset]
+	}
+
+	var work []int
+	visit := func(from instruction, to int, d int) error {
+		i := indexOf(to)
+		if depth[i] < 0 {
+			depth[i] = d
+			work = append(work, i)
+			return nil
+		}
+		if depth[i] != d {
+			return fn.errorf(from.offset, "stack depth %d at %04d conflicts with depth %d from another path",
+				d, to, depth[i])
+		}
+		return nil
+	}
+
+	if len(fn.instructions) > 0 {
+		depth[0] = 0
+		work = append(work, 0)
+	}
+
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		if i == len(fn.instructions) {
+			if fn.Constant >= 0 {
+				return fn.errorf(len(fn.ins), "function ends without returning")
+			}
+			continue
+		}
+
+		ins := fn.instructions[i]
+		pops, pushes, ok := stackEffect(ins)
+		if !ok {
+			return fn.errorf(ins.offset, "unknown stack effect for %s", ins.def.Name)
+		}
+
+		d := depth[i]
+		if d < pops {
+			return fn.errorf(ins.offset, "stack underflow: %s needs %d values, has %d", ins.def.Name, pops, d)
+		}
+
+		next := d - pops + pushes
+		if next > fn.MaxStack {
+			fn.MaxStack = next
+		}
+
+		// ジャンプした先には、取った後の値が残る
+		if code.IsJump(ins.op) {
+			if err := visit(ins, ins.operands[0], d-pops); err != nil {
+				return err
+			}
+		}
+
+		if fallsThrough(ins.op) {
+			if err := visit(ins, ins.offset+ins.def.Width(), next); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
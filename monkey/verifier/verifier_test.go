@@ -0,0 +1,247 @@
+package verifier
+
+import (
+	"errors"
+	"monkey/asm"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func TestMaxStack(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []int
+	}{
+		{"1 + 2", []int{2}},
+		{"[1, 2, 3 * 4]", []int{4}},
+		{"let a = [1]; a[0] += 2", []int{4}},
+		{"let f = fn(a, b) { a + b }; f(1, 2)", []int{3, 2}},
+		{"let f = fn(x) { fn(y) { x + y } }; f(1)(2)", []int{2, 2, 1}},
+		{"for (k, v in {1: 2}) { k }", []int{2}},
+		{"true && (false || true)", []int{2}},
+	}
+
+	for _, tt := range tests {
+		functions, err := Verify(compile(t, tt.input))
+		if err != nil {
+			t.Fatalf("%q: verify error: %s", tt.input, err)
+		}
+
+		if len(functions) != len(tt.expected) {
+			t.Fatalf("%q: wrong number of functions. want=%d, got=%d", tt.input, len(tt.expected), len(functions))
+		}
+
+		for i, want := range tt.expected {
+			if functions[i].MaxStack != want {
+				t.Errorf("%q: wrong max stack for %s. want=%d, got=%d",
+					tt.input, functions[i].Name, want, functions[i].MaxStack)
+			}
+		}
+	}
+}
+
+// コンパイラが出すバイトコードは全て検査に通る
+func TestCompiledProgramsVerify(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "harness", "testdata", "*.mk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) == 0 {
+		t.Fatal("no test programs found")
+	}
+
+	for _, file := range files {
+		input, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := parser.New(lexer.New(string(input)))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Errorf("%s: parse errors: %v", file, p.Errors())
+			continue
+		}
+
+		// バイトコードを作れないのは、コンパイルエラーを期待しているプログラムだけ
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			var compileErr *compiler.Error
+			expected, _ := os.ReadFile(strings.TrimSuffix(file, ".mk") + ".out")
+			if !errors.As(err, &compileErr) ||
+				strings.TrimSpace(string(expected)) != "aborted: "+compileErr.Message {
+				t.Errorf("%s: unexpected compile error: %s", file, err)
+			}
+			continue
+		}
+
+		if _, err := Verify(comp.Bytecode()); err != nil {
+			t.Errorf("%s: %s", file, err)
+		}
+	}
+}
+
+func TestInvalidBytecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			"unknown opcode",
+			"OpTrue\n.byte 255",
+			"<main> at 0001: opcode 255 undefined",
+		},
+		{
+			"truncated instruction",
+			"OpTrue\n.byte 0 0",
+			"<main> at 0001: OpConstant is truncated",
+		},
+		{
+			"constant out of range",
+			".const\nINTEGER 1\n.main\nOpConstant 1\nOpPop",
+			"<main> at 0000: constant 1 out of range (pool size 1)",
+		},
+		{
+			"jump into an operand",
+			"OpJump 4\nOpConstant 0",
+			"<main> at 0000: OpJump target 0004 is not the start of an instruction",
+		},
+		{
+			"jump past the end",
+			"OpJump 10\nOpNull",
+			"<main> at 0000: OpJump target 0010 is not the start of an instruction",
+		},
+		{
+			"local in main",
+			"OpGetLocal 0\nOpPop",
+			"<main> at 0000: OpGetLocal 0 out of range (NumLocals 0)",
+		},
+		{
+			"local past NumLocals",
+			".const\nfn f\n.main\nOpClosure 0 0\nOpPop\n.fn 0 locals=1 params=1\nOpTrue\nOpSetLocal 1\nOpReturn",
+			"f (constant 0) at 0001: OpSetLocal 1 out of range (NumLocals 1)",
+		},
+		{
+			"free variable out of range",
+			".const\nfn f\n.main\nOpTrue\nOpClosure 0 1\nOpPop\n.fn 0\nOpGetFree 1\nOpReturnValue",
+			"f (constant 0) at 0000: free variable 1 out of range (1 free variables)",
+		},
+		{
+			"patching a free variable no closure has",
+			".const\nfn f\n.main\nOpTrue\nOpClosure 0 1\nOpNull\nOpPatchFree 1\n.fn 0\nOpGetFree 0\nOpReturnValue",
+			"<main> at 0006: free variable 1 out of range (at most 1 free variables)",
+		},
+		{
+			"return value in main",
+			"OpTrue\nOpReturnValue",
+			"<main> at 0001: OpReturnValue outside of a function",
+		},
+		{
+			"return in main",
+			"OpReturn",
+			"<main> at 0000: OpReturn outside of a function",
+		},
+		{
+			"closure over a non-function",
+			".const\nINTEGER 1\n.main\nOpClosure 0 0",
+			"<main> at 0000: OpClosure on constant 0, which is INTEGER, not a function",
+		},
+		{
+			"builtin out of range",
+			"OpGetBuiltin 200",
+			"<main> at 0000: builtin 200 out of range (6 builtins)",
+		},
+		{
+			"odd hash",
+			"OpTrue\nOpHash 1",
+			"<main> at 0001: OpHash with an odd number of elements: 1",
+		},
+		{
+			"stack underflow",
+			"OpTrue\nOpAdd",
+			"<main> at 0001: stack underflow: OpAdd needs 2 values, has 1",
+		},
+		{
+			"paths with different depths",
+			"OpTrue\nOpJumpNotTruthy skip\nOpTrue\nskip:\nOpNull\nOpPop",
+			"<main> at 0004: stack depth 1 at 0005 conflicts with depth 0 from another path",
+		},
+		{
+			"function without return",
+			".const\nfn <anonymous>\n.main\nOpClosure 0 0\n.fn 0\nOpTrue",
+			"<anonymous> (constant 0) at 0001: function ends without returning",
+		},
+		{
+			"empty function",
+			".const\nfn f\n.main\nOpClosure 0 0\n.fn 0",
+			"f (constant 0) at 0000: function has no instructions",
+		},
+	}
+
+	for _, tt := range tests {
+		bytecode, err := asm.Assemble(tt.input)
+		if err != nil {
+			t.Fatalf("%s: assemble error: %s", tt.name, err)
+		}
+
+		_, err = Verify(bytecode)
+		if err == nil {
+			t.Errorf("%s: expected verify error", tt.name)
+			continue
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("%s: wrong error.\nwant=%q\ngot =%q", tt.name, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	bytecode := compile(t, "let x = 1;\nx + 2")
+	// OpAdd を OpSetIndex に変えて、スタックが足りなくなるようにする
+	bytecode.Instructions[12] = byte(code.OpSetIndex)
+
+	_, err := Verify(bytecode)
+	if err == nil {
+		t.Fatalf("expected verify error")
+	}
+
+	verifyErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("error is not *Error. got=%T (%+v)", err, err)
+	}
+
+	expected := token.Pos{Line: 2, Column: 3, Offset: 13}
+	if verifyErr.Pos != expected {
+		t.Errorf("wrong position. want=%+v, got=%+v", expected, verifyErr.Pos)
+	}
+
+	if err.Error() != "2:3: <main> at 0012: stack underflow: OpSetIndex needs 3 values, has 2" {
+		t.Errorf("wrong error. got=%q", err)
+	}
+}
@@ -1,10 +1,14 @@
 package vm
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math"
 	"monkey/code"
 	"monkey/compiler"
 	"monkey/object"
+	"monkey/token"
 )
 
 const StackSize = 2048
@@ -22,15 +26,113 @@ type VM struct {
 	globals     []object.Object
 	frames      []*Frame
 	framesIndex int
+
+	// 実行中の命令とその位置 エラーの報告に使う
+	currentOp  code.Opcode
+	currentPos int
+}
+
+// 実行時エラー
+// 失敗した命令と、その命令が命令列のどこにあったか、その時の呼び出し履歴を持つ
+type RuntimeError struct {
+	Opcode   code.Opcode
+	Position int
+	Err      error
+	// 失敗した関数が先頭で、mainが最後
+	Frames []TraceFrame
+}
+
+// スタックトレースの1行分
+type TraceFrame struct {
+	Function string
+	Offset   int
+	Pos      token.Pos
+}
+
+func (f TraceFrame) String() string {
+	if !f.Pos.IsValid() {
+		return fmt.Sprintf("at %s (offset %04d)", f.Function, f.Offset)
+	}
+	return fmt.Sprintf("at %s (%s)", f.Function, f.Pos)
+}
+
+func (e *RuntimeError) Error() string {
+	name := fmt.Sprintf("opcode %d", e.Opcode)
+	if def, err := code.Lookup(byte(e.Opcode)); err == nil {
+		name = def.Name
+	}
+
+	msg := fmt.Sprintf("%s (%s at %04d)", e.Err, name, e.Position)
+	if len(e.Frames) > 0 && e.Frames[0].Pos.IsValid() {
+		msg = fmt.Sprintf("%s: %s", e.Frames[0].Pos, msg)
+	}
+
+	return msg
+}
+
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// 1フレーム1行のスタックトレース
+func (e *RuntimeError) StackTrace() string {
+	var out bytes.Buffer
+
+	for _, f := range e.Frames {
+		out.WriteString("\t" + f.String() + "\n")
+	}
+
+	return out.String()
+}
+
+var errStackUnderflow = errors.New("stack underflow")
+
+func (vm *VM) newError(err error) *RuntimeError {
+	return &RuntimeError{
+		Opcode:   vm.currentOp,
+		Position: vm.currentPos,
+		Err:      err,
+		Frames:   vm.stackTrace(),
+	}
+}
+
+// 実行中のフレームを内側からたどって、どの関数のどこにいるかを集める
+func (vm *VM) stackTrace() []TraceFrame {
+	frames := []TraceFrame{}
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		// 積んだ直後でまだ1命令も実行していないフレームは、呼び出し元のOpCallで失敗している
+		if vm.frames[i].ip < 0 {
+			continue
+		}
+
+		fn := vm.frames[i].cl.Fn
+
+		name := fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+
+		offset := vm.frames[i].ip
+		pos, _ := fn.SourceMap.Lookup(offset)
+
+		frames = append(frames, TraceFrame{Function: name, Offset: offset, Pos: pos})
+	}
+
+	return frames
 }
 
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("frame overflow: more than %d nested calls", MaxFrames)
+	}
+
 	vm.frames[vm.framesIndex] = f
 	vm.framesIndex++
+
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -41,9 +143,12 @@ func (vm *VM) popFrame() *Frame {
 func New(bytecode *compiler.Bytecode) *VM {
 	mainFn := &object.CompiledFunction{
 		Instructions: bytecode.Instructions,
+		Name:         "<main>",
+		SourceMap:    bytecode.SourceMap,
 	}
 
-	mainFrame := NewFrame(mainFn, 0)
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
 
 	frames := make([]*Frame, MaxFrames)
 	frames[0] = mainFrame
@@ -64,6 +169,38 @@ func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	return vm
 }
 
+// エラーメッセージ用の演算子の表記
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpGreaterThan:  ">",
+	code.OpLessThan:     "<",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterEqual: ">=",
+	code.OpMod:          "%",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShiftLeft:    "<<",
+	code.OpShiftRight:   ">>",
+}
+
+// 評価器と同じ文言で、演算子が使えない組み合わせを報告する
+// 整数と小数は混ぜて計算できるので、型が違っても type mismatch ではなく unknown operator にする
+func operatorError(op code.Opcode, left, right object.Object) error {
+	if left.Type() != right.Type() && !(isNumber(left) && isNumber(right)) {
+		return fmt.Errorf("type mismatch: %s %s %s",
+			left.Type(), infixOperators[op], right.Type())
+	}
+
+	return fmt.Errorf("unknown operator: %s %s %s",
+		left.Type(), infixOperators[op], right.Type())
+}
+
 func nativeBooleanToBoolObject(input bool) *object.Boolean {
 	if input {
 		return True
@@ -87,10 +224,34 @@ func (vm *VM) executeBinaryIntegerOperation(
 		result = leftValue - rightValue
 	case code.OpMul:
 		result = leftValue * rightValue
-	case code.OpDiv:
-		result = leftValue / rightValue
+	case code.OpDiv, code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+
+		if op == code.OpDiv {
+			result = leftValue / rightValue
+		} else {
+			result = leftValue % rightValue
+		}
+	case code.OpBitAnd:
+		result = leftValue & rightValue
+	case code.OpBitOr:
+		result = leftValue | rightValue
+	case code.OpBitXor:
+		result = leftValue ^ rightValue
+	case code.OpShiftLeft, code.OpShiftRight:
+		if rightValue < 0 {
+			return fmt.Errorf("negative shift count: %d", rightValue)
+		}
+
+		if op == code.OpShiftLeft {
+			result = leftValue << uint64(rightValue)
+		} else {
+			result = leftValue >> uint64(rightValue)
+		}
 	default:
-		return fmt.Errorf("unkown integer operator: %d", op)
+		return operatorError(op, left, right)
 	}
 
 	return vm.push(&object.Integer{
@@ -99,12 +260,41 @@ func (vm *VM) executeBinaryIntegerOperation(
 
 }
 
+// 整数と小数が混ざっていたら、両方小数にして計算する
+func (vm *VM) executeBinaryFloatOperation(
+	op code.Opcode,
+	left, right object.Object) error {
+
+	leftValue, _ := floatValue(left)
+	rightValue, _ := floatValue(right)
+
+	var result float64
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
+	default:
+		return operatorError(op, left, right)
+	}
+
+	return vm.push(&object.Float{
+		Value: result,
+	})
+}
+
 func (vm *VM) executeBinaryStringOperation(
 	op code.Opcode,
 	left, right object.Object) error {
 
 	if op != code.OpAdd {
-		return fmt.Errorf("unkown string operator: %d", op)
+		return operatorError(op, left, right)
 	}
 
 	leftValue := left.(*object.String).Value
@@ -125,10 +315,12 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	switch {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeBinaryFloatOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
-		return fmt.Errorf("unsupported types for binary operation:%s %s", leftType, rightType)
+		return operatorError(op, left, right)
 	}
 }
 
@@ -150,12 +342,14 @@ func (vm *VM) executeBangOperator() error {
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
 
-	if operand.Type() != object.INTEGER_OBJ {
-		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+	switch operand := operand.(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
+		return fmt.Errorf("unknown operator: -%s", operand.Type())
 	}
-
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
 }
 func (vm *VM) executeIntegerComparison(
 	op code.Opcode,
@@ -171,12 +365,60 @@ func (vm *VM) executeIntegerComparison(
 		return vm.push(nativeBooleanToBoolObject(leftValue != rightValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBooleanToBoolObject(leftValue > rightValue))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBooleanToBoolObject(leftValue >= rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBooleanToBoolObject(leftValue < rightValue))
+	case code.OpLessEqual:
+		return vm.push(nativeBooleanToBoolObject(leftValue <= rightValue))
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return operatorError(op, left, right)
 	}
 
 }
 
+func (vm *VM) executeFloatComparison(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue, _ := floatValue(left)
+	rightValue, _ := floatValue(right)
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBooleanToBoolObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBooleanToBoolObject(leftValue != rightValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBooleanToBoolObject(leftValue > rightValue))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBooleanToBoolObject(leftValue >= rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBooleanToBoolObject(leftValue < rightValue))
+	case code.OpLessEqual:
+		return vm.push(nativeBooleanToBoolObject(leftValue <= rightValue))
+	default:
+		return operatorError(op, left, right)
+	}
+}
+
+func (vm *VM) executeStringComparison(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBooleanToBoolObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBooleanToBoolObject(leftValue != rightValue))
+	default:
+		return operatorError(op, left, right)
+	}
+}
+
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
@@ -185,14 +427,38 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 		return vm.executeIntegerComparison(op, left, right)
 	}
 
+	if isNumber(left) && isNumber(right) {
+		return vm.executeFloatComparison(op, left, right)
+	}
+
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left, right)
+	}
+
 	switch op {
 	case code.OpEqual:
 		return vm.push(nativeBooleanToBoolObject(left == right))
 	case code.OpNotEqual:
 		return vm.push(nativeBooleanToBoolObject(left != right))
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)",
-			op, left.Type(), right.Type())
+		return operatorError(op, left, right)
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	_, ok := floatValue(obj)
+	return ok
+}
+
+// 整数か小数ならfloat64に変換する
+func floatValue(obj object.Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value), true
+	case *object.Float:
+		return obj.Value, true
+	default:
+		return 0, false
 	}
 }
 
@@ -220,7 +486,7 @@ func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 }
 
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairts := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
@@ -235,13 +501,10 @@ func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
 			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
 		}
 
-		hashedPairts[hashKey.HashKey()] = pair
-
+		hash.Set(hashKey.HashKey(), pair)
 	}
 
-	return &object.Hash{
-		Pairs: hashedPairts,
-	}, nil
+	return hash, nil
 }
 
 func (vm *VM) executeArrayIndex(array, index object.Object) error {
@@ -285,7 +548,144 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	}
 }
 
-func (vm *VM) Run() error {
+// 配列やハッシュの中身をその場で書き換え、代入した値を積む
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("index operator not supported: %s[%s]",
+				left.Type(), index.Type())
+		}
+
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("index out of range: %d (length %d)",
+				i.Value, len(left.Elements))
+		}
+
+		left.Elements[i.Value] = value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+
+		left.Set(key.HashKey(), object.HashPair{Key: index, Value: value})
+	default:
+		return fmt.Errorf("index operator not supported: %s", left.Type())
+	}
+
+	return vm.push(value)
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	// 引数はスタック上で関数の直後に積まれている
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+
+	// basePointerは最初の引数を指すので、引数がそのままローカル変数の先頭になる
+	frame := NewFrame(cl, vm.sp-numArgs)
+	err := vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	if frame.basePointer+cl.Fn.NumLocals > StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Fn(args...)
+	// 引数と組み込み関数そのものをスタックから取り除く
+	vm.sp = vm.sp - numArgs - 1
+
+	// 評価器と同じく、組み込み関数のエラーでも実行を止める
+	if errObj, ok := result.(*object.Error); ok {
+		return errors.New(errObj.Message)
+	}
+
+	if result != nil {
+		return vm.push(result)
+	}
+
+	return vm.push(Null)
+}
+
+func (vm *VM) pushClosure(constIndex, numFree int) error {
+	constant, err := vm.constant(constIndex)
+	if err != nil {
+		return err
+	}
+
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	// 自由変数はOpClosureの直前にスタックへ積まれている
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+// 定数プールから取り出す 範囲外ならエラー
+func (vm *VM) constant(index int) (object.Object, error) {
+	if index >= len(vm.constants) {
+		return nil, fmt.Errorf("constant index out of range: %d (pool size %d)",
+			index, len(vm.constants))
+	}
+
+	return vm.constants[index], nil
+}
+
+// バイトコードを実行する
+// 失敗したときは*RuntimeErrorを返す 想定外のGoのpanicも*Errorに変換するので
+// 不正なバイトコードを渡してもプロセスごと落ちることはない
+func (vm *VM) Run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			cause, ok := r.(error)
+			if !ok || !errors.Is(cause, errStackUnderflow) {
+				cause = fmt.Errorf("internal error: %v", r)
+			}
+			err = vm.newError(cause)
+		}
+	}()
+
+	if err := vm.run(); err != nil {
+		return vm.newError(err)
+	}
+
+	return nil
+}
+
+func (vm *VM) run() error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
@@ -298,12 +698,20 @@ func (vm *VM) Run() error {
 		ins = vm.currentFrame().Instructions()
 		op = code.Opcode(ins[ip])
 
+		vm.currentOp = op
+		vm.currentPos = ip
+
 		switch op {
 		case code.OpConstant:
 			constIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
-			err := vm.push(vm.constants[constIndex])
+			constant, err := vm.constant(int(constIndex))
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(constant)
 			if err != nil {
 				return err
 			}
@@ -338,9 +746,24 @@ func (vm *VM) Run() error {
 			if !isTruthy(condition) {
 				vm.currentFrame().ip = pos - 1
 			}
+		case code.OpJumpTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			condition := vm.pop()
+
+			if isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpDup:
+			err := vm.push(vm.stack[vm.sp-1])
+			if err != nil {
+				return err
+			}
 		case code.OpPop:
 			vm.pop()
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor,
+			code.OpShiftLeft, code.OpShiftRight:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
@@ -370,7 +793,8 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan,
+			code.OpGreaterEqual, code.OpLessThan, code.OpLessEqual:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
@@ -408,15 +832,35 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpCall:
-			fn, ok := vm.stack[vm.sp-1].(*object.CompiledFunction)
-			if !ok {
-				return fmt.Errorf("calling non-function")
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+
+		case code.OpDup2:
+			err := vm.push(vm.stack[vm.sp-2])
+			if err != nil {
+				return err
 			}
 
-			frame := NewFrame(fn, vm.sp)
-			vm.pushFrame(frame)
-			vm.sp = frame.basePointer + fn.NumLocals
+			err = vm.push(vm.stack[vm.sp-2])
+			if err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeCall(int(numArgs))
+			if err != nil {
+				return err
+			}
 
 		case code.OpSetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
@@ -432,9 +876,107 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			err := vm.pushClosure(int(constIndex), int(numFree))
+			if err != nil {
+				return err
+			}
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if int(builtinIndex) >= len(object.Builtins) {
+				return fmt.Errorf("undefined builtin: %d", builtinIndex)
+			}
+
+			definition := object.Builtins[builtinIndex]
+			err := vm.push(definition.Builtin)
+			if err != nil {
+				return err
+			}
+		case code.OpIterInit:
+			iterable := vm.pop()
+			it, ok := object.NewIterator(iterable)
+			if !ok {
+				return fmt.Errorf("not iterable: %s", iterable.Type())
+			}
+
+			err := vm.push(it)
+			if err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			numVars := int(code.ReadUint8(ins[ip+3:]))
+			vm.currentFrame().ip += 3
+
+			operand := vm.pop()
+			it, ok := operand.(*object.Iterator)
+			if !ok {
+				return fmt.Errorf("not an iterator: %s", operand.Type())
+			}
+
+			key, value, ok := it.Next(numVars)
+			if !ok {
+				vm.currentFrame().ip = pos - 1
+				continue
+			}
+
+			if numVars == 2 {
+				err := vm.push(key)
+				if err != nil {
+					return err
+				}
+			}
+
+			err := vm.push(value)
+			if err != nil {
+				return err
+			}
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure)
+			if err != nil {
+				return err
+			}
+		case code.OpPatchFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			value := vm.pop()
+			target := vm.pop()
+			closure, ok := target.(*object.Closure)
+			if !ok {
+				return fmt.Errorf("not a closure: %+v", target)
+			}
+
+			if int(freeIndex) >= len(closure.Free) {
+				return fmt.Errorf("free variable %d out of range (%d free variables)",
+					freeIndex, len(closure.Free))
+			}
+
+			closure.Free[freeIndex] = value
 		case code.OpReturnValue:
 			returnValue := vm.pop()
 
+			// メインから戻るときは、その値を最後の値にして実行を終える
+			if vm.framesIndex == 1 {
+				return nil
+			}
+
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
 			err := vm.push(returnValue)
@@ -444,6 +986,14 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpReturn:
+			if vm.framesIndex == 1 {
+				if err := vm.push(Null); err != nil {
+					return err
+				}
+				vm.pop()
+				return nil
+			}
+
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
 			err := vm.push(Null)
@@ -451,6 +1001,9 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+
+		default:
+			return fmt.Errorf("unknown opcode %d", op)
 		}
 
 	}
@@ -469,7 +1022,13 @@ func (vm *VM) push(o object.Object) error {
 	return nil
 }
 
+// 空のスタックからは取り出せない
+// 呼び出し元すべてでエラーを確かめる代わりにpanicし、Runでエラーに戻す
 func (vm *VM) pop() object.Object {
+	if vm.sp <= 0 {
+		panic(errStackUnderflow)
+	}
+
 	o := vm.stack[vm.sp-1]
 	vm.sp--
 	return o
@@ -1,12 +1,15 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 	"monkey/ast"
+	"monkey/code"
 	"monkey/compiler"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"strings"
 	"testing"
 )
 
@@ -16,6 +19,22 @@ func parse(input string) *ast.Program {
 	return p.ParseProgram()
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)",
+			actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
 func testIntergerObject(expected int64, actual object.Object) error {
 	result, ok := actual.(*object.Integer)
 
@@ -103,6 +122,12 @@ func testExpectedObject(
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
 
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
+
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -167,6 +192,18 @@ func testExpectedObject(
 		if actual != Null {
 			t.Errorf("object is not Null: %T (%+v)", actual, actual)
 		}
+
+	case *object.Error:
+		errObj, ok := actual.(*object.Error)
+		if !ok {
+			t.Errorf("object is not Error: %T (%+v)", actual, actual)
+			return
+		}
+
+		if errObj.Message != expected.Message {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				expected.Message, errObj.Message)
+		}
 	}
 }
 
@@ -193,6 +230,47 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestIntegerBitwiseAndModulo(t *testing.T) {
+	tests := []vmTestCase{
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 4", 16},
+		{"256 >> 4", 16},
+		{"-16 >> 2", -4},
+		{"1 + 2 << 3", 17},
+		{"1 | 2 & 3", 3},
+		{"7.5 % 2", 1.5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5", 1.5},
+		{".5", 0.5},
+		{"1e-3", 0.001},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"10 * 0.25", 2.5},
+		{"7 / 2.0", 3.5},
+		{"7 / 2", 3},
+		{"-2.5", -2.5},
+		{"1.0 / 0.0 > 1e308", true},
+		{"1.5 > 1", true},
+		{"1 < 1.5", true},
+		{"2 == 2.0", true},
+		{"2.5 != 2.5", false},
+		{"let pct = fn(part, total) { part * 100.0 / total }; pct(1, 8)", 12.5},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestBooleanExperessions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -220,6 +298,65 @@ func TestBooleanExperessions(t *testing.T) {
 		{"!!true", true},
 		{"!!false", false},
 		{"!!5", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 <= 1", false},
+		{"1 >= 0.5", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestOperandEvaluationOrder(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let log = [];
+			let f = fn(x) { log = push(log, x); x };
+			f(1) < f(2);
+			log
+			`,
+			expected: []int{1, 2},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", 2},
+		{"1 || 2", 1},
+		{"0 && 2", 2},
+		{`"" || 3`, ""},
+		{"let n = fn() {}; n() && 1", Null},
+		{"let n = fn() {}; n() || 5", 5},
+		{"if (false) { 1 } || 7", 7},
+		{"[] && 8", 8},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"!(false || false) && true", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLogicalShortCircuit(t *testing.T) {
+	tests := []vmTestCase{
+		{"let calls = 0; let f = fn() { calls += 1; true }; false && f(); calls", 0},
+		{"let calls = 0; let f = fn() { calls += 1; true }; true && f(); calls", 1},
+		{"let calls = 0; let f = fn() { calls += 1; true }; true || f(); calls", 0},
+		{"let calls = 0; let f = fn() { calls += 1; true }; false || f(); calls", 1},
+		{"let arr = []; len(arr) > 0 && arr[0] > 1", false},
+		{"let d = {}; d[\"k\"] || \"default\"", "default"},
 	}
 
 	runVmTests(t, tests)
@@ -258,6 +395,8 @@ func TestStringExpressions(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"monkey" == "mon" + "key"`, true},
+		{`"monkey" != "monkey"`, false},
 	}
 
 	runVmTests(t, tests)
@@ -361,6 +500,10 @@ func TestFunctionWitReturnStatement(t *testing.T) {
 			`,
 			expected: 99,
 		},
+		// メインでのreturnは実行を終える
+		{input: "return 5; 6", expected: 5},
+		{input: "let x = 1; while (true) { x += 1; if (x > 3) { return x; } }; 0", expected: 4},
+		{input: "[1, if (true) { return 7; } else { 2 }]; 3", expected: 7},
 	}
 
 	runVmTests(t, tests)
@@ -467,3 +610,805 @@ func TestCallingFunctionsWithBindings(t *testing.T) {
 
 	runVmTests(t, tests)
 }
+
+func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let identity = fn(a) { a; };
+			identity(4);
+			`,
+			expected: 4,
+		},
+		{
+			input: `
+			let sum = fn(a, b) { a + b; };
+			sum(1, 2);
+			`,
+			expected: 3,
+		},
+		{
+			input: `
+			let sum = fn(a, b) {
+				let c = a + b;
+				c;
+			};
+			sum(1, 2);
+			`,
+			expected: 3,
+		},
+		{
+			input: `
+			let sum = fn(a, b) {
+				let c = a + b;
+				c;
+			};
+			sum(1, 2) + sum(3, 4);
+			`,
+			expected: 10,
+		},
+		{
+			input: `
+			let sum = fn(a, b) {
+				let c = a + b;
+				c;
+			};
+			let outer = fn() {
+				sum(1, 2) + sum(3, 4);
+			};
+			outer();
+			`,
+			expected: 10,
+		},
+		{
+			input: `
+			let globalNum = 10;
+
+			let sum = fn(a, b) {
+				let c = a + b;
+				c + globalNum;
+			};
+
+			let outer = fn() {
+				sum(1, 2) + sum(3, 4) + globalNum;
+			};
+
+			outer() + globalNum;
+			`,
+			expected: 50,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithWrongArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input:    `fn() { 1; }(1);`,
+			expected: `wrong number of arguments: want=0, got=1`,
+		},
+		{
+			input:    `fn(a) { a; }();`,
+			expected: `wrong number of arguments: want=1, got=0`,
+		},
+		{
+			input:    `fn(a, b) { a + b; }(1);`,
+			expected: `wrong number of arguments: want=2, got=1`,
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if errors.Unwrap(err).Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let newClosure = fn(a) {
+				fn() { a; };
+			};
+			let closure = newClosure(99);
+			closure();
+			`,
+			expected: 99,
+		},
+		{
+			input: `
+			let newAdder = fn(a, b) {
+				fn(c) { a + b + c };
+			};
+			let adder = newAdder(1, 2);
+			adder(8);
+			`,
+			expected: 11,
+		},
+		{
+			input: `
+			let newAdder = fn(a, b) {
+				let c = a + b;
+				fn(d) { c + d };
+			};
+			let adder = newAdder(1, 2);
+			adder(8);
+			`,
+			expected: 11,
+		},
+		{
+			input: `
+			let newAdderOuter = fn(a, b) {
+				let c = a + b;
+				fn(d) {
+					let e = d + c;
+					fn(f) { e + f; };
+				};
+			};
+			let newAdderInner = newAdderOuter(1, 2)
+			let adder = newAdderInner(3);
+			adder(8);
+			`,
+			expected: 14,
+		},
+		{
+			input: `
+			let a = 1;
+			let newAdderOuter = fn(b) {
+				fn(c) {
+					fn(d) { a + b + c + d };
+				};
+			};
+			let newAdderInner = newAdderOuter(2)
+			let adder = newAdderInner(3);
+			adder(8);
+			`,
+			expected: 14,
+		},
+		{
+			input: `
+			let newClosure = fn(a, b) {
+				let one = fn() { a; };
+				let two = fn() { b; };
+				fn() { one() + two(); };
+			};
+			let closure = newClosure(9, 90);
+			closure();
+			`,
+			expected: 99,
+		},
+		{
+			input: `
+			let adder = fn(x) { fn(y) { x + y } };
+			adder(1)(2) + adder(10)(20);
+			`,
+			expected: 33,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let countDown = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					countDown(x - 1);
+				}
+			};
+			countDown(1);
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let countDown = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					countDown(x - 1);
+				}
+			};
+			let wrapper = fn() {
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) {
+					if (x == 0) {
+						return 0;
+					} else {
+						countDown(x - 1);
+					}
+				};
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let fibonacci = fn(x) {
+					if (x == 0) {
+						return 0;
+					} else {
+						if (x == 1) {
+							return 1;
+						} else {
+							fibonacci(x - 1) + fibonacci(x - 2);
+						}
+					}
+				};
+				fibonacci(15);
+			};
+			wrapper();
+			`,
+			expected: 610,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMutuallyRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+			let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+			isEven(10);
+			`,
+			expected: true,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+				let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+				isOdd(7);
+			};
+			wrapper();
+			`,
+			expected: true,
+		},
+		{
+			input: `
+			let wrapper = fn(base) {
+				let a = fn(n) { if (n == 0) { base } else { b(n - 1) + 1 } };
+				let b = fn(n) { if (n == 0) { base } else { c(n - 1) + 10 } };
+				let c = fn(n) { if (n == 0) { base } else { a(n - 1) + 100 } };
+				a(4);
+			};
+			wrapper(1000);
+			`,
+			expected: 1112,
+		},
+		{
+			// 間に他の文があっても、後ろで定義される関数を呼び出せる
+			input: `
+			let a = fn(n) { if (n == 0) { 0 } else { b(n - 1) } };
+			let z = 1;
+			let b = fn(n) { if (n == 0) { z } else { a(n - 1) } };
+			let wrapper = fn() {
+				let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+				let half = 0 == 0;
+				let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+				if (half && isOdd(7)) { a(3) } else { 0 };
+			};
+			wrapper();
+			`,
+			expected: 1,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`puts("hello", "world!")`, Null},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, Null},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, Null},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`rest([])`, Null},
+		{`push([], 1)`, []int{1}},
+		{`let f = fn(arr) { len(arr) }; f([1, 2])`, 2},
+		{`let f = fn() { fn(arr) { push(arr, 3) } }; f()([1])`, []int{1, 3}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestWhileStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"while (false) { 1 }; 2", 2},
+		{"while (true) { break; }; 5", 5},
+		{"while (true) { if (false) { continue; } break; }; 1", 1},
+		{
+			input: `
+			let f = fn() {
+				while (true) {
+					if (true) { return 7; }
+				}
+			};
+			f();
+			`,
+			expected: 7,
+		},
+		{
+			input: `
+			let f = fn(n) {
+				while (true) {
+					while (true) { break; }
+					return n;
+				}
+			};
+			f(3);
+			`,
+			expected: 3,
+		},
+		{
+			input: `
+			let f = fn() {
+				while (true) { break; }
+			};
+			f();
+			`,
+			expected: Null,
+		},
+		{"if (true) { let x = 1; }", Null},
+		{"if (false) { 1 } else { while (false) { } }", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let collect = fn(arr) {
+				let result = [];
+				for (x in arr) {
+					result = push(result, x * 2);
+				}
+				result;
+			};
+			collect([1, 2, 3]);
+			`,
+			expected: []int{2, 4, 6},
+		},
+		{"let last = 0; for (i, x in [5, 6, 7]) { last = i + x; }; last", 9},
+		{`let keys = []; for (k in {"b": 1, "a": 2}) { keys = push(keys, k); }; keys[0] + keys[1]`, "ba"},
+		{`let sum = 0; for (k, v in {"b": 1, "a": 2}) { sum += v; }; sum`, 3},
+		{`let s = ""; for (c in "abc") { s = c + s; }; s`, "cba"},
+		{"let n = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } n = x; }; n", 2},
+		{"let n = 0; for (x in [1, 2, 3]) { if (x == 2) { continue; } n += x; }; n", 4},
+		// ループの変数と本体の中でletした名前は、ループの外からは見えない
+		{"let n = 0; for (x in [1, 2, 3]) { let n = n + x; }; n", 0},
+		{"let x = 10; for (x in [1, 2]) { x }; x", 10},
+		{"let i = 0; while (i < 3) { let j = i; i += 1; }; let j = 5; j", 5},
+		{
+			input: `
+			let find = fn(arr, target) {
+				for (i, x in arr) {
+					if (x == target) { return i; }
+				}
+				-1;
+			};
+			[find([4, 5, 6], 6), find([4, 5, 6], 7)];
+			`,
+			expected: []int{2, -1},
+		},
+		{"for (x in []) { x }; 10", 10},
+		{`{"b": 1, "a": 2, "b": 3}`, map[object.HashKey]int64{
+			(&object.String{Value: "b"}).HashKey(): 3,
+			(&object.String{Value: "a"}).HashKey(): 2,
+		}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHashInspectOrder(t *testing.T) {
+	program := parse(`{"b": 1, "a": 2, 3: 3, "b": 4}`)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	expected := "{b: 4, a: 2, 3: 3}"
+	if vm.LastPoppedStackElem().Inspect() != expected {
+		t.Errorf("wrong hash order. want=%q, got=%q",
+			expected, vm.LastPoppedStackElem().Inspect())
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let i = 0; while (i < 5) { i += 1; }; i", 5},
+		{"let f = fn() { let x = 1; x += 41; x }; f()", 42},
+		{"let arr = [1, 2, 3]; arr[1] = 20; arr", []int{1, 20, 3}},
+		{"let arr = [1, 2, 3]; arr[2] *= 10; arr", []int{1, 2, 30}},
+		{"let arr = [1, 2, 3]; let alias = arr; alias[0] = 9; arr[0]", 9},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 5; h["a"] + h["b"]`, 7},
+		{`let h = {}; h[1] = "one"; h[1]`, "one"},
+		{
+			input: `
+			let calls = 0;
+			let index = fn() { calls += 1; 0 };
+			let arr = [5];
+			arr[index()] += 1;
+			[arr[0], calls]
+			`,
+			expected: []int{6, 1},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestInvalidIndexAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [1]; a[1] = 2;", "index out of range: 1 (length 1)"},
+		{"let a = [1]; a[-1] = 2;", "index out of range: -1 (length 1)"},
+		{`let a = [1]; a["x"] = 2;`, "index operator not supported: ARRAY[STRING]"},
+		{`let h = {}; h[[1]] = 2;`, "unusable as hash key: ARRAY"},
+		{`let s = "abc"; s[0] = "x";`, "index operator not supported: STRING"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if errors.Unwrap(err).Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 / 0", "1:3: division by zero (OpDiv at 0006)"},
+		{"5 % 0", "1:3: division by zero (OpMod at 0006)"},
+		{"let x = 0; fn() { 10 / x }()", "1:22: division by zero (OpDiv at 0006)"},
+		{
+			"let f = fn() { f() }; f()",
+			"1:17: frame overflow: more than 1024 nested calls (OpCall at 0001)",
+		},
+		{
+			"let f = fn(n) { f(n + 1) }; f(0)",
+			"1:23: stack overflow (OpConstant at 0003)",
+		},
+		// 組み込み関数のエラーも実行を止める
+		{"len(1); 5", "1:4: argument to `len` not supported, got INTEGER (OpCall at 0005)"},
+		{`len("one", "two")`, "1:4: wrong number of arguments. got=2, want=1 (OpCall at 0008)"},
+		{"first(1)", "1:6: argument to `first` must be ARRAY, got INTEGER (OpCall at 0005)"},
+		{"last(1)", "1:5: argument to `last` must be ARRAY, got INTEGER (OpCall at 0005)"},
+		{"push(1, 1)", "1:5: argument to `push` must be ARRAY, got INTEGER (OpCall at 0008)"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestMalformedBytecodeErrors(t *testing.T) {
+	tests := []struct {
+		instructions []code.Instructions
+		constants    []object.Object
+		expected     string
+	}{
+		{
+			instructions: []code.Instructions{
+				code.Make(code.OpConstant, 3),
+			},
+			constants: []object.Object{&object.Integer{Value: 1}},
+			expected:  "constant index out of range: 3 (pool size 1) (OpConstant at 0000)",
+		},
+		{
+			instructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpPop),
+			},
+			expected: "stack underflow (OpPop at 0002)",
+		},
+		{
+			instructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+			},
+			expected: "constant index out of range: 0 (pool size 0) (OpClosure at 0000)",
+		},
+		{
+			instructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpPatchFree, 0),
+			},
+			constants: []object.Object{&object.CompiledFunction{Instructions: code.Make(code.OpReturn)}},
+			expected:  "free variable 0 out of range (0 free variables) (OpPatchFree at 0005)",
+		},
+		{
+			instructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				{255},
+			},
+			expected: "unknown opcode 255 (opcode 255 at 0001)",
+		},
+	}
+
+	for _, tt := range tests {
+		ins := code.Instructions{}
+		for _, i := range tt.instructions {
+			ins = append(ins, i...)
+		}
+
+		vm := New(&compiler.Bytecode{Instructions: ins, Constants: tt.constants})
+		err := vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		var vmErr *RuntimeError
+		if !errors.As(err, &vmErr) {
+			t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestReturnFromMain(t *testing.T) {
+	// コンパイラはメインにOpReturnValueを出さないが、手で組んだバイトコードでも壊れない
+	tests := []struct {
+		instructions []code.Instructions
+		expected     object.Object
+	}{
+		{
+			[]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpReturnValue),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+			True,
+		},
+		{
+			[]code.Instructions{
+				code.Make(code.OpReturn),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+			Null,
+		},
+	}
+
+	for _, tt := range tests {
+		ins := code.Instructions{}
+		for _, i := range tt.instructions {
+			ins = append(ins, i...)
+		}
+
+		vm := New(&compiler.Bytecode{Instructions: ins})
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		if got := vm.LastPoppedStackElem(); got != tt.expected {
+			t.Errorf("wrong result. want=%s, got=%v", tt.expected.Inspect(), got)
+		}
+	}
+}
+
+func TestRecoverFromPanic(t *testing.T) {
+	// 引数が足りないOpArrayはスタックの外を読もうとする
+	ins := code.Make(code.OpArray, 5)
+
+	vm := New(&compiler.Bytecode{Instructions: ins})
+	err := vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	if !strings.HasPrefix(err.Error(), "internal error: ") {
+		t.Errorf("wrong VM error. got=%q", err)
+	}
+
+	if !strings.HasSuffix(err.Error(), "(OpArray at 0000)") {
+		t.Errorf("error does not have location. got=%q", err)
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	input := `let divide = fn(a, b) {
+	a / b
+};
+let average = fn(arr) {
+	divide(arr[0] + arr[1], len(arr) - 2)
+};
+average([1, 2])`
+
+	l := lexer.NewWithFile("avg.mk", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	expected := []struct {
+		function string
+		pos      string
+	}{
+		{"divide", "avg.mk:2:4"},
+		{"average", "avg.mk:5:8"},
+		{"<main>", "avg.mk:7:8"},
+	}
+
+	if len(runtimeErr.Frames) != len(expected) {
+		t.Fatalf("wrong number of frames. want=%d, got=%d (%+v)",
+			len(expected), len(runtimeErr.Frames), runtimeErr.Frames)
+	}
+
+	for i, e := range expected {
+		frame := runtimeErr.Frames[i]
+		if frame.Function != e.function {
+			t.Errorf("frame %d: wrong function. want=%q, got=%q", i, e.function, frame.Function)
+		}
+		if frame.Pos.String() != e.pos {
+			t.Errorf("frame %d: wrong position. want=%q, got=%q", i, e.pos, frame.Pos)
+		}
+	}
+
+	expectedTrace := "\tat divide (avg.mk:2:4)\n\tat average (avg.mk:5:8)\n\tat <main> (avg.mk:7:8)\n"
+	if runtimeErr.StackTrace() != expectedTrace {
+		t.Errorf("wrong stack trace. want=%q, got=%q", expectedTrace, runtimeErr.StackTrace())
+	}
+}
+
+func TestStackTraceAnonymousFunction(t *testing.T) {
+	comp := compiler.New()
+	err := comp.Compile(parse("fn() { -true }()"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	if runtimeErr.Frames[0].Function != "<anonymous>" {
+		t.Errorf("wrong function name. got=%q", runtimeErr.Frames[0].Function)
+	}
+}
+
+func TestStackTraceSkipsFramesNotStarted(t *testing.T) {
+	// 5つのローカル変数を確保しようとしたところでスタックが尽きる
+	input := "let f = fn(n) { let a = 1; let b = 2; let c = 3; let d = 4; let e = 5; f(n) }; f(0)"
+
+	program := parse(input)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	if runtimeErr.Error() != "1:73: stack overflow (OpCall at 0028)" {
+		t.Errorf("wrong error. got=%q", runtimeErr.Error())
+	}
+
+	for i, frame := range runtimeErr.Frames {
+		if frame.Offset < 0 {
+			t.Errorf("frame %d has no instruction yet. got=%+v", i, frame)
+		}
+	}
+
+	if top := runtimeErr.Frames[0]; top.Function != "f" || top.Pos.String() != "1:73" {
+		t.Errorf("top frame is not the failing call. got=%+v", top)
+	}
+}